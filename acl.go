@@ -0,0 +1,135 @@
+package imap
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Right is a permission of the ACL extension (RFC 4314).
+type Right byte
+
+const (
+	RightLookup     Right = 'l' // mailbox is visible to LIST and LSUB
+	RightRead       Right = 'r' // SELECT, EXAMINE, FETCH, SEARCH, COPY from
+	RightSeen       Right = 's' // keep \Seen across sessions
+	RightWrite      Right = 'w' // set flags other than \Seen and \Deleted
+	RightInsert     Right = 'i' // APPEND and COPY into
+	RightPost       Right = 'p' // send mail to the submission address
+	RightCreate     Right = 'k' // create child mailboxes
+	RightDelete     Right = 'x' // delete the mailbox
+	RightDeleteMsg  Right = 't' // set \Deleted
+	RightExpunge    Right = 'e' // EXPUNGE
+	RightAdminister Right = 'a' // SETACL, DELETEACL, GETACL, LISTRIGHTS
+)
+
+// Rights is a set of rights, written like "lrswi".
+type Rights string
+
+// Has reports whether r is in the set.
+func (s Rights) Has(r Right) bool {
+	return strings.IndexByte(string(s), byte(r)) >= 0
+}
+
+// ACL maps identifiers, like a user name or "anyone", to their rights.
+type ACL map[string]Rights
+
+// GetACL returns the access control list of mailbox.
+func (c *IMAPClient) GetACL(mailbox string) (ACL, error) {
+	resp := c.Do(fmt.Sprintf("GETACL %s", c.mailboxName(mailbox)))
+	if resp.Error() != nil {
+		return nil, resp.Error()
+	}
+	fields, err := aclReply(resp, "ACL")
+	if err != nil {
+		return nil, err
+	}
+	if len(fields)%2 != 0 {
+		return nil, errors.New("Invalid ACL response")
+	}
+	ret := make(ACL)
+	for i := 2; i < len(fields); i += 2 {
+		id, ok := fields[i].(string)
+		rights, isRights := fields[i+1].(string)
+		if !ok || !isRights {
+			return nil, errors.New("Invalid ACL response")
+		}
+		ret[id] = Rights(rights)
+	}
+	return ret, nil
+}
+
+// SetACL changes the rights of identifier on mailbox. rights replaces the
+// current rights, unless it starts with "+" to add to them or "-" to remove
+// from them.
+func (c *IMAPClient) SetACL(mailbox, identifier string, rights Rights) error {
+	return c.Do(fmt.Sprintf("SETACL %s %s %s", c.mailboxName(mailbox), quoteString(identifier), quoteString(string(rights)))).Error()
+}
+
+// DeleteACL removes identifier from the access control list of mailbox.
+func (c *IMAPClient) DeleteACL(mailbox, identifier string) error {
+	return c.Do(fmt.Sprintf("DELETEACL %s %s", c.mailboxName(mailbox), quoteString(identifier))).Error()
+}
+
+// ListRights returns the rights identifier always has on mailbox, and the
+// groups of rights which can be granted to it, where the rights of a group
+// are granted together.
+func (c *IMAPClient) ListRights(mailbox, identifier string) (Rights, []Rights, error) {
+	resp := c.Do(fmt.Sprintf("LISTRIGHTS %s %s", c.mailboxName(mailbox), quoteString(identifier)))
+	if resp.Error() != nil {
+		return "", nil, resp.Error()
+	}
+	fields, err := aclReply(resp, "LISTRIGHTS")
+	if err != nil {
+		return "", nil, err
+	}
+	if len(fields) < 4 {
+		return "", nil, errors.New("Invalid LISTRIGHTS response")
+	}
+	strs := make([]Rights, 0, len(fields)-3)
+	for _, f := range fields[3:] {
+		rights, ok := f.(string)
+		if !ok {
+			return "", nil, errors.New("Invalid LISTRIGHTS response")
+		}
+		strs = append(strs, Rights(rights))
+	}
+	return strs[0], strs[1:], nil
+}
+
+// MyRights returns the rights of the logged in user on mailbox.
+func (c *IMAPClient) MyRights(mailbox string) (Rights, error) {
+	resp := c.Do(fmt.Sprintf("MYRIGHTS %s", c.mailboxName(mailbox)))
+	if resp.Error() != nil {
+		return "", resp.Error()
+	}
+	fields, err := aclReply(resp, "MYRIGHTS")
+	if err != nil {
+		return "", err
+	}
+	if len(fields) != 3 {
+		return "", errors.New("Invalid MYRIGHTS response")
+	}
+	rights, ok := fields[2].(string)
+	if !ok {
+		return "", errors.New("Invalid MYRIGHTS response")
+	}
+	return Rights(rights), nil
+}
+
+// aclReply returns the fields of the first reply named name.
+func aclReply(resp *Response, name string) ([]interface{}, error) {
+	for _, reply := range resp.Replys() {
+		fields, err := reply.Fields()
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) < 2 {
+			continue
+		}
+		if n, _ := fields[0].(string); strings.ToUpper(n) == name {
+			return fields, nil
+		}
+	}
+	return nil, errors.New("Invalid response")
+}
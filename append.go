@@ -0,0 +1,174 @@
+package imap
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// dateTimeLayout is the layout of the IMAP date-time, as in INTERNALDATE.
+const dateTimeLayout = "02-Jan-2006 15:04:05 -0700"
+
+// AppendUID is the APPENDUID response code of UIDPLUS (RFC 4315): the
+// UIDVALIDITY of the mailbox and the UIDs the appended messages got, in order.
+type AppendUID struct {
+	UIDValidity uint32
+	UIDs        SeqSet
+}
+
+// AppendMessage is a message to add with AppendMulti. Flags and Date are
+// optional. The message is either read from Body, which is Size bytes long,
+// or composed from Parts with CATENATE (RFC 4469). Binary sends Body as a
+// literal8, for messages with binary parts, which needs BINARY (RFC 3516).
+// UTF8 marks a Body with UTF-8 headers (RFC 6532), which is sent as a UTF8
+// literal and needs UTF8=ACCEPT to be enabled.
+type AppendMessage struct {
+	Flags  []Flag
+	Date   time.Time
+	Body   io.Reader
+	Size   int64
+	Binary bool
+	UTF8   bool
+	Parts  []CatenatePart
+}
+
+// CatenatePart is a part of a message composed with CATENATE. It's either the
+// IMAP URL of content already on the server, like
+// "/INBOX;UIDVALIDITY=385759045/;UID=20/;SECTION=1.MIME", or new text read from
+// Text, which is Size bytes long.
+type CatenatePart struct {
+	URL  string
+	Text io.Reader
+	Size int64
+}
+
+// Append adds the message read from r, which is size bytes long, to mailbox.
+// flags and date are optional, a zero date lets the server use the current
+// time. With UIDPLUS it returns the UID the message got, otherwise nil.
+//
+// If the mailbox doesn't exist and the server answers [TRYCREATE] before the
+// message was sent, Append creates the mailbox and tries again. Otherwise the
+// error is a *ResponseError with Code "TRYCREATE".
+func (c *IMAPClient) Append(mailbox string, flags []Flag, date time.Time, r io.Reader, size int64) (*AppendUID, error) {
+	return c.AppendMulti(mailbox, []*AppendMessage{
+		{
+			Flags: flags,
+			Date:  date,
+			Body:  r,
+			Size:  size,
+		},
+	})
+}
+
+// AppendMulti adds msgs to mailbox like Append. With MULTIAPPEND (RFC 3502)
+// they are sent in one command, which either adds all or none of them;
+// otherwise they are appended one by one, and when one fails the ones before it
+// stay appended: their UIDs are returned along with the error.
+func (c *IMAPClient) AppendMulti(mailbox string, msgs []*AppendMessage) (*AppendUID, error) {
+	if len(msgs) > 1 && !c.HasCapability("MULTIAPPEND") {
+		var ret *AppendUID
+		for _, msg := range msgs {
+			uid, err := c.AppendMulti(mailbox, []*AppendMessage{msg})
+			if err != nil {
+				return ret, err
+			}
+			if uid != nil {
+				if ret == nil {
+					ret = &AppendUID{UIDValidity: uid.UIDValidity}
+				}
+				ret.UIDs = append(ret.UIDs, uid.UIDs...)
+			}
+		}
+		return ret, nil
+	}
+
+	parts := []interface{}{fmt.Sprintf("APPEND %s", c.mailboxName(mailbox))}
+	literals := make([]*literal, 0)
+	for _, msg := range msgs {
+		for _, f := range msg.Flags {
+			if !f.valid() {
+				return nil, fmt.Errorf("invalid flag %q", f)
+			}
+		}
+		args := ""
+		if len(msg.Flags) > 0 {
+			args += " " + FlagSet(msg.Flags).String()
+		}
+		if !msg.Date.IsZero() {
+			args += fmt.Sprintf(" \"%s\"", msg.Date.Format(dateTimeLayout))
+		}
+		if len(msg.Parts) == 0 {
+			l := &literal{
+				r:      msg.Body,
+				size:   msg.Size,
+				binary: msg.Binary,
+			}
+			if msg.UTF8 {
+				if !c.Enabled("UTF8=ACCEPT") {
+					return nil, errors.New("UTF8=ACCEPT isn't enabled")
+				}
+				l.binary = true
+				parts = append(parts, args+" UTF8 (", l, ")")
+			} else {
+				parts = append(parts, args+" ", l)
+			}
+			literals = append(literals, l)
+			continue
+		}
+		parts = append(parts, args+" CATENATE (")
+		for i, part := range msg.Parts {
+			sep := " "
+			if i == 0 {
+				sep = ""
+			}
+			if part.Text == nil {
+				parts = append(parts, fmt.Sprintf("%sURL %s", sep, quoteString(part.URL)))
+				continue
+			}
+			l := &literal{
+				r:    part.Text,
+				size: part.Size,
+			}
+			parts = append(parts, sep+"TEXT ", l)
+			literals = append(literals, l)
+		}
+		parts = append(parts, ")")
+	}
+
+	resp := c.do(parts...)
+	if e, ok := resp.Error().(*ResponseError); ok && e.Code == "TRYCREATE" && (len(literals) == 0 || !literals[0].sent) {
+		if err := c.Create(mailbox); err != nil {
+			return nil, err
+		}
+		resp = c.do(parts...)
+	}
+	if resp.Error() != nil {
+		return nil, resp.Error()
+	}
+	return parseAppendUID(resp.Status())
+}
+
+// parseAppendUID reads the APPENDUID code of status, if there is one.
+func parseAppendUID(status string) (*AppendUID, error) {
+	code, args := responseCode(status)
+	if code != "APPENDUID" {
+		return nil, nil
+	}
+	if len(args) != 2 {
+		return nil, errors.New("Invalid APPENDUID response code")
+	}
+	validity, ok := fieldNumber(args[0])
+	set, isString := args[1].(string)
+	if !ok || !isString {
+		return nil, errors.New("Invalid APPENDUID response code")
+	}
+	uids, err := parseSeqSet(set)
+	if err != nil {
+		return nil, err
+	}
+	return &AppendUID{
+		UIDValidity: uint32(validity),
+		UIDs:        uids,
+	}, nil
+}
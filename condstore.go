@@ -0,0 +1,81 @@
+package imap
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// FetchChanges fetches items of the messages in uidSet whose mod-sequence is
+// above modseq (CHANGEDSINCE). When QRESYNC is enabled it also returns the
+// UIDs in uidSet expunged since modseq.
+func (c *IMAPClient) FetchChanges(uidSet string, modseq uint64, items ...string) ([]*FetchData, SeqSet, error) {
+	modifier := fmt.Sprintf("CHANGEDSINCE %d", modseq)
+	if c.Enabled("QRESYNC") {
+		modifier += " VANISHED"
+	}
+	resp := c.Do(fmt.Sprintf("UID FETCH %s (%s) (%s)", uidSet, strings.Join(items, " "), modifier))
+	if resp.Error() != nil {
+		return nil, nil, resp.Error()
+	}
+	datas, err := fetchReplies(resp)
+	if err != nil {
+		return nil, nil, err
+	}
+	vanished := make(SeqSet, 0)
+	if !resp.Enabled("QRESYNC") {
+		return datas, vanished, nil
+	}
+	for _, reply := range resp.Replys() {
+		fields, err := reply.Fields()
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		if name, ok := fields[0].(string); !ok || strings.ToUpper(name) != "VANISHED" {
+			continue
+		}
+		uids, err := parseVanished(fields)
+		if err != nil {
+			return nil, nil, err
+		}
+		vanished = append(vanished, uids...)
+	}
+	return datas, vanished, nil
+}
+
+// StoreIfUnchanged is Store with the UNCHANGEDSINCE modifier: messages whose
+// mod-sequence is above modseq are left alone, and returned in modified.
+func (c *IMAPClient) StoreIfUnchanged(id string, modseq uint64, mode StoreMode, silent bool, flags ...Flag) ([]*FetchData, SeqSet, error) {
+	resp, err := c.store(false, id, fmt.Sprintf("(UNCHANGEDSINCE %d) ", modseq), mode, silent, flags)
+	if err != nil {
+		return nil, nil, err
+	}
+	datas, err := fetchReplies(resp)
+	if err != nil {
+		return nil, nil, err
+	}
+	modified := make(SeqSet, 0)
+	if code, args := responseCode(resp.Status()); code == "MODIFIED" && len(args) > 0 {
+		set, _ := args[0].(string)
+		modified, err = parseSeqSet(set)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return datas, modified, nil
+}
+
+// parseVanished reads the UIDs of "VANISHED [(EARLIER)] uid-set".
+func parseVanished(fields []interface{}) (SeqSet, error) {
+	if len(fields) < 2 {
+		return nil, errors.New("Invalid VANISHED response")
+	}
+	set, ok := fields[len(fields)-1].(string)
+	if !ok {
+		return nil, errors.New("Invalid VANISHED response")
+	}
+	return parseSeqSet(set)
+}
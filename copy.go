@@ -0,0 +1,121 @@
+package imap
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// CopyUID is the COPYUID response code of UIDPLUS (RFC 4315): the
+// UIDVALIDITY of the destination mailbox, and the UIDs of the source messages
+// with the UIDs their copies got, in the same order.
+type CopyUID struct {
+	UIDValidity uint32
+	Source      SeqSet
+	Dest        SeqSet
+}
+
+// DestOf returns the UID the copy of the source message uid got.
+func (c *CopyUID) DestOf(uid uint32) (uint32, bool) {
+	i, ok := c.Source.index(uid)
+	if !ok {
+		return 0, false
+	}
+	return c.Dest.at(i)
+}
+
+// Copy copies the messages in id, which is a sequence set, to mailbox. With
+// UIDPLUS it returns the UIDs of the copies, otherwise nil.
+func (c *IMAPClient) Copy(id, mailbox string) (*CopyUID, error) {
+	return c.copy("COPY", id, mailbox)
+}
+
+// UIDCopy is Copy with a UID set.
+func (c *IMAPClient) UIDCopy(uid, mailbox string) (*CopyUID, error) {
+	return c.copy("UID COPY", uid, mailbox)
+}
+
+// Move moves the messages in id, which is a sequence set, to mailbox. Without
+// MOVE (RFC 6851) it copies them, marks them \Deleted and removes them with
+// UID EXPUNGE, which needs UIDPLUS so that no other message is expunged.
+func (c *IMAPClient) Move(id, mailbox string) (*CopyUID, error) {
+	if c.HasCapability("MOVE") {
+		return c.copy("MOVE", id, mailbox)
+	}
+	datas, err := c.FetchItems(id, "UID")
+	if err != nil {
+		return nil, err
+	}
+	if len(datas) == 0 {
+		return nil, nil
+	}
+	uids := make([]string, len(datas))
+	for i, data := range datas {
+		uids[i] = fmt.Sprint(data.UID)
+	}
+	return c.UIDMove(strings.Join(uids, ","), mailbox)
+}
+
+// UIDMove is Move with a UID set.
+func (c *IMAPClient) UIDMove(uid, mailbox string) (*CopyUID, error) {
+	if c.HasCapability("MOVE") {
+		return c.copy("UID MOVE", uid, mailbox)
+	}
+	if !c.HasCapability("UIDPLUS") {
+		return nil, errors.New("server supports neither MOVE nor UIDPLUS")
+	}
+	ret, err := c.UIDCopy(uid, mailbox)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := c.store(true, uid, "", StoreAdd, true, []Flag{Deleted}); err != nil {
+		return nil, err
+	}
+	if _, err := c.UIDExpunge(uid); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func (c *IMAPClient) copy(cmd, id, mailbox string) (*CopyUID, error) {
+	resp := c.Do(fmt.Sprintf("%s %s %s", cmd, id, c.mailboxName(mailbox)))
+	if resp.Error() != nil {
+		return nil, resp.Error()
+	}
+	return parseCopyUID(resp)
+}
+
+func parseCopyUID(resp *Response) (*CopyUID, error) {
+	// MOVE sends COPYUID in an untagged OK, before the expunges.
+	texts := []string{resp.Status()}
+	for _, reply := range resp.Replys() {
+		texts = append(texts, reply.Origin())
+	}
+	for _, text := range texts {
+		code, args := responseCode(text)
+		if code != "COPYUID" {
+			continue
+		}
+		if len(args) != 3 {
+			return nil, errors.New("Invalid COPYUID response code")
+		}
+		validity, ok := fieldNumber(args[0])
+		source, isSource := args[1].(string)
+		dest, isDest := args[2].(string)
+		if !ok || !isSource || !isDest {
+			return nil, errors.New("Invalid COPYUID response code")
+		}
+		ret := &CopyUID{
+			UIDValidity: uint32(validity),
+		}
+		var err error
+		if ret.Source, err = parseSeqSet(source); err != nil {
+			return nil, err
+		}
+		if ret.Dest, err = parseSeqSet(dest); err != nil {
+			return nil, err
+		}
+		return ret, nil
+	}
+	return nil, nil
+}
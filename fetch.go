@@ -0,0 +1,280 @@
+package imap
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// FETCH items of PREVIEW (RFC 8970), SAVEDATE (RFC 8514) and OBJECTID
+// (RFC 8474). "PREVIEW (LAZY)" lets the server return NIL instead of
+// computing a preview it doesn't have yet.
+const (
+	ItemPreview  = "PREVIEW"
+	ItemSaveDate = "SAVEDATE"
+	ItemEmailID  = "EMAILID"
+	ItemThreadID = "THREADID"
+)
+
+// FetchData is the typed form of an untagged FETCH response. Items the server
+// didn't send are left zero.
+type FetchData struct {
+	SeqNum uint32
+	UID    uint32
+	Flags  FlagSet
+	ModSeq uint64
+
+	// Gmail items, see the Gmail* constants.
+	GmailMsgID    uint64
+	GmailThreadID uint64
+	GmailLabels   []string
+
+	// Binary and BinarySize map BINARY sections, like "1.2" or "" for the
+	// whole message, to their decoded content and size.
+	Binary     map[string][]byte
+	BinarySize map[string]uint32
+
+	// Body is the whole message, when BODY[] or BODY.PEEK[] was fetched.
+	// Sections holds the other fetched BODY[section] contents, by section,
+	// like "1.2" or "1.HEADER"; partial ones are cut where the server cut
+	// them.
+	Body     []byte
+	Sections map[string][]byte
+
+	// bodyStructure is the BODYSTRUCTURE as read by reply.Fields.
+	bodyStructure interface{}
+
+	// Preview is empty when the server had none. SaveDate is zero when the
+	// mailbox doesn't keep it, and ThreadID empty when the server doesn't
+	// thread the message.
+	Preview  string
+	SaveDate time.Time
+	EmailID  string
+	ThreadID string
+}
+
+// FetchItems fetches the given data items, like FLAGS or MODSEQ, of the
+// messages in id, which is a sequence set.
+func (c *IMAPClient) FetchItems(id string, items ...string) ([]*FetchData, error) {
+	return c.fetch(fmt.Sprintf("FETCH %s (%s)", id, strings.Join(items, " ")))
+}
+
+// UIDFetchItems is FetchItems with a UID set.
+func (c *IMAPClient) UIDFetchItems(uid string, items ...string) ([]*FetchData, error) {
+	return c.fetch(fmt.Sprintf("UID FETCH %s (%s)", uid, strings.Join(items, " ")))
+}
+
+func (c *IMAPClient) fetch(cmd string) ([]*FetchData, error) {
+	resp := c.Do(cmd)
+	if resp.Error() != nil {
+		return nil, resp.Error()
+	}
+	datas, err := fetchReplies(resp)
+	if err != nil {
+		return nil, err
+	}
+	for _, data := range datas {
+		for i, label := range data.GmailLabels {
+			data.GmailLabels[i] = c.decodeMailbox(label)
+		}
+	}
+	return datas, nil
+}
+
+func parseFetch(fields []interface{}) (*FetchData, error) {
+	if len(fields) < 3 {
+		return nil, errors.New("Invalid FETCH response")
+	}
+	seq, ok := fieldNumber(fields[0])
+	items, isList := fields[2].([]interface{})
+	if !ok || !isList || len(items)%2 != 0 {
+		return nil, errors.New("Invalid FETCH response")
+	}
+	ret := &FetchData{
+		SeqNum: uint32(seq),
+	}
+	for i := 0; i < len(items); i += 2 {
+		name, _ := items[i].(string)
+		value := items[i+1]
+		if section, ok := fetchSection(name, "BINARY"); ok {
+			content, _ := fieldString(value)
+			if ret.Binary == nil {
+				ret.Binary = make(map[string][]byte)
+			}
+			ret.Binary[section] = []byte(content)
+			continue
+		}
+		if section, ok := fetchSection(name, "BODY"); ok {
+			content, _ := fieldString(value)
+			if section == "" {
+				ret.Body = []byte(content)
+				continue
+			}
+			if ret.Sections == nil {
+				ret.Sections = make(map[string][]byte)
+			}
+			ret.Sections[section] = []byte(content)
+			continue
+		}
+		if section, ok := fetchSection(name, "BINARY.SIZE"); ok {
+			n, ok := fieldNumber(value)
+			if !ok {
+				return nil, errors.New("Invalid BINARY.SIZE in FETCH response")
+			}
+			if ret.BinarySize == nil {
+				ret.BinarySize = make(map[string]uint32)
+			}
+			ret.BinarySize[section] = uint32(n)
+			continue
+		}
+		switch strings.ToUpper(name) {
+		case "UID":
+			n, ok := fieldNumber(value)
+			if !ok {
+				return nil, errors.New("Invalid UID in FETCH response")
+			}
+			ret.UID = uint32(n)
+		case "FLAGS":
+			flags, err := parseFlagSet(value)
+			if err != nil {
+				return nil, err
+			}
+			ret.Flags = flags
+		case "MODSEQ":
+			list, _ := value.([]interface{})
+			if len(list) != 1 {
+				return nil, errors.New("Invalid MODSEQ in FETCH response")
+			}
+			n, ok := fieldNumber(list[0])
+			if !ok {
+				return nil, errors.New("Invalid MODSEQ in FETCH response")
+			}
+			ret.ModSeq = n
+		case "BODYSTRUCTURE":
+			ret.bodyStructure = value
+		case ItemPreview:
+			if ret.Preview, ok = fieldString(value); !ok {
+				return nil, errors.New("Invalid PREVIEW in FETCH response")
+			}
+		case ItemSaveDate:
+			if value == nil {
+				continue
+			}
+			str, _ := value.(string)
+			date, err := parseDateTime(str)
+			if err != nil {
+				return nil, err
+			}
+			ret.SaveDate = date
+		case ItemEmailID, ItemThreadID:
+			id, err := parseObjectID(value)
+			if err != nil {
+				return nil, err
+			}
+			if strings.ToUpper(name) == ItemEmailID {
+				ret.EmailID = id
+			} else {
+				ret.ThreadID = id
+			}
+		case GmailMsgID, GmailThreadID:
+			n, ok := fieldNumber(value)
+			if !ok {
+				return nil, fmt.Errorf("Invalid %s in FETCH response", name)
+			}
+			if strings.ToUpper(name) == GmailMsgID {
+				ret.GmailMsgID = n
+			} else {
+				ret.GmailThreadID = n
+			}
+		case GmailLabels:
+			list, ok := value.([]interface{})
+			if !ok {
+				return nil, errors.New("Invalid X-GM-LABELS in FETCH response")
+			}
+			ret.GmailLabels = make([]string, 0, len(list))
+			for _, i := range list {
+				label, ok := i.(string)
+				if !ok {
+					return nil, errors.New("Invalid X-GM-LABELS in FETCH response")
+				}
+				ret.GmailLabels = append(ret.GmailLabels, label)
+			}
+		}
+	}
+	return ret, nil
+}
+
+// parseDateTime parses an IMAP date-time, whose day may be space padded.
+func parseDateTime(str string) (time.Time, error) {
+	return time.Parse("2-Jan-2006 15:04:05 -0700", strings.TrimSpace(str))
+}
+
+// parseObjectID reads an object ID like "(M6d99ac3275bb4e)", or NIL.
+func parseObjectID(field interface{}) (string, error) {
+	if field == nil {
+		return "", nil
+	}
+	list, ok := field.([]interface{})
+	if !ok || len(list) != 1 {
+		return "", errors.New("Invalid object ID")
+	}
+	id, ok := list[0].(string)
+	if !ok {
+		return "", errors.New("Invalid object ID")
+	}
+	return id, nil
+}
+
+// fetchSection returns the section of a FETCH item like "BINARY[1.2]<0>" if
+// the item is called name.
+func fetchSection(item, name string) (string, bool) {
+	if !strings.HasPrefix(strings.ToUpper(item), name+"[") {
+		return "", false
+	}
+	end := strings.Index(item, "]")
+	if end < 0 {
+		return "", false
+	}
+	return item[len(name)+1 : end], true
+}
+
+// FetchBinary returns the content of section of the message id, like "1.2"
+// or "" for the whole message, decoded by the server from its
+// Content-Transfer-Encoding (BINARY, RFC 3516). A server unable to decode it
+// answers with a *ResponseError of Code "UNKNOWN-CTE".
+func (c *IMAPClient) FetchBinary(id, section string) ([]byte, error) {
+	datas, err := c.FetchItems(id, fmt.Sprintf("BINARY.PEEK[%s]", section))
+	if err != nil {
+		return nil, err
+	}
+	for _, data := range datas {
+		if content, ok := data.Binary[section]; ok {
+			return content, nil
+		}
+	}
+	return nil, errors.New("Invalid response")
+}
+
+// fetchReplies collects the FETCH responses among the replies of resp.
+func fetchReplies(resp *Response) ([]*FetchData, error) {
+	ret := make([]*FetchData, 0)
+	for _, reply := range resp.Replys() {
+		fields, err := reply.Fields()
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) < 2 {
+			continue
+		}
+		if name, _ := fields[1].(string); strings.ToUpper(name) != "FETCH" {
+			continue
+		}
+		data, err := parseFetch(fields)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, data)
+	}
+	return ret, nil
+}
@@ -0,0 +1,114 @@
+package imap
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Flag is a message flag. System flags start with a backslash, anything else
+// is a keyword, like $Forwarded or a user defined label.
+type Flag string
+
+const (
+	Seen     Flag = "\\Seen"
+	Answered Flag = "\\Answered"
+	Flagged  Flag = "\\Flagged"
+	Deleted  Flag = "\\Deleted"
+	Draft    Flag = "\\Draft"
+	Recent   Flag = "\\Recent"
+)
+
+// IsKeyword reports whether f is a keyword instead of a system flag.
+func (f Flag) IsKeyword() bool {
+	return !strings.HasPrefix(string(f), "\\")
+}
+
+func (f Flag) valid() bool {
+	name := strings.TrimPrefix(string(f), "\\")
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if c <= ' ' || c >= 0x7f || strings.ContainsRune("(){%*\"\\]", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// FlagSet is a list of flags, as sent in or received from a flag list.
+type FlagSet []Flag
+
+// Has reports whether f is in the set. Flags compare case-insensitively.
+func (s FlagSet) Has(f Flag) bool {
+	for _, i := range s {
+		if strings.EqualFold(string(i), string(f)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s FlagSet) String() string {
+	strs := make([]string, len(s))
+	for i, f := range s {
+		strs[i] = string(f)
+	}
+	return "(" + strings.Join(strs, " ") + ")"
+}
+
+func parseFlagSet(field interface{}) (FlagSet, error) {
+	list, ok := field.([]interface{})
+	if !ok {
+		return nil, errors.New("Invalid flag list")
+	}
+	ret := make(FlagSet, 0, len(list))
+	for _, i := range list {
+		s, ok := i.(string)
+		if !ok {
+			return nil, errors.New("Invalid flag list")
+		}
+		ret = append(ret, Flag(s))
+	}
+	return ret, nil
+}
+
+// StoreMode tells STORE how to apply the given flags.
+type StoreMode string
+
+const (
+	StoreReplace StoreMode = "FLAGS"
+	StoreAdd     StoreMode = "+FLAGS"
+	StoreRemove  StoreMode = "-FLAGS"
+)
+
+// Store changes the flags of the messages in id, which is a sequence set.
+// Unless silent is true, the server answers with the new flags of every
+// message, which are returned.
+func (c *IMAPClient) Store(id string, mode StoreMode, silent bool, flags ...Flag) ([]*FetchData, error) {
+	resp, err := c.store(false, id, "", mode, silent, flags)
+	if err != nil {
+		return nil, err
+	}
+	return fetchReplies(resp)
+}
+
+// store sends STORE, or UID STORE when uid is set and id is a UID set.
+func (c *IMAPClient) store(uid bool, id, modifier string, mode StoreMode, silent bool, flags []Flag) (*Response, error) {
+	for _, f := range flags {
+		if !f.valid() {
+			return nil, fmt.Errorf("invalid flag %q", f)
+		}
+	}
+	item := string(mode)
+	if silent {
+		item += ".SILENT"
+	}
+	cmd := "STORE"
+	if uid {
+		cmd = "UID STORE"
+	}
+	resp := c.Do(fmt.Sprintf("%s %s %s%s %s", cmd, id, modifier, item, FlagSet(flags)))
+	return resp, resp.Error()
+}
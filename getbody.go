@@ -2,93 +2,103 @@ package imap
 
 import (
 	"bytes"
-	"net/mail"
-	"mime"
-	"fmt"
-	"mime/multipart"
-	"encoding/base64"
-	"github.com/googollee/go-encoding-ex"
-	"io"
+	"errors"
 	"io/ioutil"
+	"net/mail"
 	"strings"
 )
 
+// GetBody returns the content of the part of msg to show, with its media type
+// and charset. In a multipart it prefers the part of preferType, then one of
+// the same major type, like text/html for text/plain, then looks into the
+// first nested multipart. Only the parts that may be chosen are kept in
+// memory while msg is read.
 func GetBody(msg *mail.Message, preferType string) (string, string, string, error) {
-	var mediatype string
-	var typeparams map[string]string
-Select:
-	for {
-		var err error
-		mediatype, typeparams, err = mime.ParseMediaType(msg.Header.Get("Content-Type"))
-		if err != nil {
-			return "", "", "", err
+	major := strings.Split(preferType, "/")[0]
+	var root *bodyCandidate
+	frames := make(map[string]*bodyFrame)
+	err := Walk(msg, func(part *Part) error {
+		c := &bodyCandidate{part: part}
+		if part.IsMultipart() {
+			c.frame = &bodyFrame{}
+			frames[part.Section] = c.frame
 		}
-		err = nil
-		switch mediatype {
-		case "multipart/alternative":
-			fallthrough
-		case "multipart/mixed":
-			fallthrough
-		case "multipart/related":
-			msg, err = selectMultiPart(msg, typeparams["boundary"], preferType)
-			if err != nil {
-				break Select
-			}
-		default:
-			break Select
+		wanted := true
+		if root == nil {
+			root = c
+		} else {
+			parent := frames[parentSection(part.Section)]
+			wanted = !parent.dead && parent.add(c, preferType, major)
 		}
+		if c.frame != nil {
+			c.frame.dead = !wanted
+			return nil
+		}
+		if !wanted {
+			return nil
+		}
+		body, err := ioutil.ReadAll(part.Body)
 		if err != nil {
-			return "", "", "", fmt.Errorf("parse mail content error: %s", err)
+			return err
 		}
+		part.Body = bytes.NewReader(body)
+		return nil
+	})
+	if err != nil {
+		return "", "", "", err
 	}
-
-	encoding := msg.Header.Get("Content-Transfer-Encoding")
-	var reader io.Reader
-	switch encoding {
-	case "base64":
-		reader = base64.NewDecoder(base64.StdEncoding, encodingex.NewIgnoreReader(msg.Body, []byte("\r\n")))
-	case "quoted-printable":
-		reader = encodingex.NewQuotedPrintableDecoder(msg.Body)
-	default:
-		reader = msg.Body
+	if root == nil {
+		return "", "", "", errors.New("empty message")
+	}
+	part := root.choose()
+	if part == nil {
+		return "", "", "", errors.New("parse mail content error: No prefered part")
 	}
+	body, err := ioutil.ReadAll(part.Body)
+	return string(body), part.MediaType, part.Params["charset"], err
+}
 
-	body, err := ioutil.ReadAll(reader)
-	return string(body), mediatype, typeparams["charset"], err
+// bodyFrame is a multipart walked by GetBody, with the candidates among its
+// children for the part to show. Nothing is kept of a dead one, which can't
+// be chosen.
+type bodyFrame struct {
+	dead                bool
+	exact, major, multi *bodyCandidate
 }
 
-func selectMultiPart(msg *mail.Message, boundary, preferType string) (*mail.Message, error) {
-	reader := multipart.NewReader(msg.Body, boundary)
-	parts := make(map[string]*mail.Message)
-	for part, err := reader.NextPart(); err != io.EOF; part, err = reader.NextPart() {
-		if err != nil {
-			return nil, err
-		}
-		header := mail.Header(part.Header)
-		mediatype, _, err := mime.ParseMediaType(header.Get("Content-Type"))
-		if err != nil {
-			continue
-		}
-		if mediatype == preferType {
-			return &mail.Message{header, part}, nil
-		}
-		types := strings.Split(mediatype, "/")
-		if len(types) == 0 {
-			continue
-		}
-		if _, ok := parts[types[0]]; !ok {
-			body, err := ioutil.ReadAll(part)
-			if err == nil {
-				parts[types[0]] = &mail.Message{header, bytes.NewBuffer(body)}
-			}
-		}
+// bodyCandidate is a leaf part, whose body is buffered, or a multipart with
+// its frame.
+type bodyCandidate struct {
+	part  *Part
+	frame *bodyFrame
+}
+
+// add records c, whose part is a child of f, if it's a candidate.
+func (f *bodyFrame) add(c *bodyCandidate, preferType, major string) bool {
+	mediatype := c.part.MediaType
+	switch {
+	case f.exact != nil:
+		return false
+	case mediatype == preferType:
+		f.exact = c
+	case f.major == nil && strings.Split(mediatype, "/")[0] == major:
+		f.major = c
+	case f.multi == nil && c.frame != nil:
+		f.multi = c
+	default:
+		return false
 	}
-	types := strings.Split(preferType, "/")
-	if part, ok := parts[types[0]]; ok {
-		return part, nil
+	return true
+}
+
+func (c *bodyCandidate) choose() *Part {
+	if c.frame == nil {
+		return c.part
 	}
-	if part, ok := parts["multipart"]; ok {
-		return part, nil
+	for _, child := range []*bodyCandidate{c.frame.exact, c.frame.major, c.frame.multi} {
+		if child != nil {
+			return child.choose()
+		}
 	}
-	return nil, fmt.Errorf("No prefered part")
+	return nil
 }
@@ -0,0 +1,45 @@
+package imap
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FETCH items of Gmail's X-GM-EXT-1 extension.
+const (
+	GmailMsgID    = "X-GM-MSGID"
+	GmailThreadID = "X-GM-THRID"
+	GmailLabels   = "X-GM-LABELS"
+)
+
+// StoreGmailLabels changes the Gmail labels of the messages in id, like Store
+// does with flags. System labels like \Inbox or \Important start with a
+// backslash. Unless silent is true, the new labels of every message are
+// returned.
+func (c *IMAPClient) StoreGmailLabels(id string, mode StoreMode, silent bool, labels ...string) ([]*FetchData, error) {
+	item := strings.Replace(string(mode), "FLAGS", GmailLabels, 1)
+	if silent {
+		item += ".SILENT"
+	}
+	strs := make([]string, len(labels))
+	for i, label := range labels {
+		if strings.HasPrefix(label, "\\") && Flag(label).valid() {
+			strs[i] = label
+		} else {
+			strs[i] = c.mailboxName(label)
+		}
+	}
+	return c.fetch(fmt.Sprintf("STORE %s %s (%s)", id, item, strings.Join(strs, " ")))
+}
+
+// GmailSearch returns the sequence numbers of the messages matching query,
+// written in Gmail's web search syntax, like "has:attachment in:unread".
+func (c *IMAPClient) GmailSearch(query string) ([]string, error) {
+	if !c.needsLiteral(query) {
+		return parseSearch(c.Do(fmt.Sprintf("SEARCH X-GM-RAW %s", quoteString(query))))
+	}
+	return parseSearch(c.do("SEARCH CHARSET UTF-8 X-GM-RAW ", &literal{
+		r:    strings.NewReader(query),
+		size: int64(len(query)),
+	}))
+}
@@ -0,0 +1,65 @@
+package imap
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ID tells the server who the client is with the ID extension (RFC 2971),
+// like {"name": "goimap", "version": "1.0"}, and returns what the server says
+// about itself. fields may be nil, and so may be the returned map.
+func (c *IMAPClient) ID(fields map[string]string) (map[string]string, error) {
+	arg := "NIL"
+	if len(fields) > 0 {
+		keys := make([]string, 0, len(fields))
+		for k := range fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		strs := make([]string, 0, len(fields)*2)
+		for _, k := range keys {
+			strs = append(strs, quoteString(k), quoteString(fields[k]))
+		}
+		arg = "(" + strings.Join(strs, " ") + ")"
+	}
+	resp := c.Do(fmt.Sprintf("ID %s", arg))
+	if resp.Error() != nil {
+		return nil, resp.Error()
+	}
+	return parseID(resp)
+}
+
+func parseID(resp *Response) (map[string]string, error) {
+	for _, reply := range resp.Replys() {
+		fields, err := reply.Fields()
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) < 2 {
+			continue
+		}
+		if name, _ := fields[0].(string); strings.ToUpper(name) != "ID" {
+			continue
+		}
+		if fields[1] == nil {
+			return nil, nil
+		}
+		list, ok := fields[1].([]interface{})
+		if !ok || len(list)%2 != 0 {
+			return nil, errors.New("Invalid ID response")
+		}
+		ret := make(map[string]string)
+		for i := 0; i < len(list); i += 2 {
+			key, ok := list[i].(string)
+			value, isValue := fieldString(list[i+1])
+			if !ok || !isValue {
+				return nil, errors.New("Invalid ID response")
+			}
+			ret[key] = value
+		}
+		return ret, nil
+	}
+	return nil, errors.New("Invalid response")
+}
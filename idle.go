@@ -0,0 +1,86 @@
+package imap
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Idle waits for updates with IDLE (RFC 2177) until stop is closed, sending
+// every untagged reply to Updates as soon as it arrives. Servers end IDLE
+// after about 30 minutes, so it should be restarted more often than that.
+func (c *IMAPClient) Idle(stop <-chan struct{}) error {
+	updates := c.updates()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.touch()
+	c.count++
+	resp := NewResponse()
+	resp.enabled = c.enabled
+	if _, err := c.w.Write([]byte(fmt.Sprintf("a%03d IDLE\r\n", c.count))); err != nil {
+		return err
+	}
+	if err := c.flush(); err != nil {
+		return err
+	}
+	if err := c.read(resp); err != nil {
+		return err
+	}
+	if !resp.continued {
+		if resp.Error() != nil {
+			return resp.Error()
+		}
+		return errors.New("server didn't accept IDLE")
+	}
+	resp.continued = false
+
+	// The goroutine sending DONE is waited for, so that a DONE racing with the
+	// server ending IDLE by itself can't land in the next command.
+	var doneMu sync.Mutex
+	finished := false
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	defer func() {
+		doneMu.Lock()
+		finished = true
+		doneMu.Unlock()
+		close(done)
+		<-exited
+	}()
+	go func() {
+		defer close(exited)
+		select {
+		case <-stop:
+		case <-done:
+			return
+		}
+		doneMu.Lock()
+		defer doneMu.Unlock()
+		if finished {
+			return
+		}
+		// The read loop below is blocked, waiting for the server to answer.
+		c.w.Write([]byte("DONE\r\n"))
+		c.flush()
+	}()
+
+	delivered := 0
+	for {
+		chunk, err := c.readChunk()
+		if err != nil {
+			return err
+		}
+		isFinished, err := resp.Feed(chunk)
+		if err != nil {
+			return err
+		}
+		for ; delivered < len(resp.replys); delivered++ {
+			sendUpdate(updates, resp.replys[delivered])
+		}
+		if isFinished {
+			c.keep(chunk[resp.consumed:])
+			c.touch()
+			return resp.Error()
+		}
+	}
+}
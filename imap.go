@@ -3,28 +3,55 @@ package imap
 import (
 	"bufio"
 	"bytes"
+	"compress/flate"
 	"crypto/tls"
 	"errors"
 	"fmt"
 	"github.com/googollee/go-encoding-ex"
+	"io"
 	"net"
 	"net/mail"
 	"net/textproto"
 	"strings"
+	"sync"
+	"time"
 )
 
 const (
 	RFC822Header = "rfc822.header"
 	RFC822Text   = "rfc822.text"
-	Seen         = "\\Seen"
-	Deleted      = "\\Deleted"
 	Inbox        = "INBOX"
 )
 
 type IMAPClient struct {
-	conn  *tls.Conn
-	count int
-	buf   []byte
+	conn    net.Conn
+	r       io.Reader
+	w       io.Writer
+	deflate *flate.Writer
+	count   int
+	buf     []byte
+	pending []byte
+	caps    map[string]bool
+	enabled map[string]bool
+	// condStoreBySelect is set when CONDSTORE was turned on by SELECT
+	// (CONDSTORE) rather than ENABLE, which the server may not have.
+	condStoreBySelect bool
+
+	selected *selection
+
+	// mu serializes commands, which the keepalive loop sends too.
+	mu sync.Mutex
+	// broken is set, under mu, when a command was cut in the middle and the
+	// connection closed.
+	broken bool
+
+	// stateMu guards the fields below. It's never held while waiting for the
+	// server, so Close and StopKeepalive don't wait for a running command.
+	stateMu       sync.Mutex
+	lastUsed      time.Time
+	updateChan    chan reply
+	keepaliveStop chan struct{}
+	keepalive     time.Duration
 }
 
 func NewClient(conn net.Conn, hostname string) (*IMAPClient, error) {
@@ -49,55 +76,328 @@ REPLY:
 		}
 	}
 	return &IMAPClient{
-		conn: c,
-		buf:  buf,
+		conn:    c,
+		r:       c,
+		w:       c,
+		buf:     buf,
+		enabled: make(map[string]bool),
 	}, nil
 }
 
+// Close closes the connection. A command running in another goroutine, or
+// Idle, is aborted and returns an error.
 func (c *IMAPClient) Close() error {
+	c.StopKeepalive()
 	return c.conn.Close()
 }
 
 func (c *IMAPClient) Do(cmd string) *Response {
-	c.count++
-	cmd = fmt.Sprintf("a%03d %s\r\n", c.count, cmd)
+	return c.do(cmd)
+}
+
+// literal is a command argument sent as an IMAP literal, or as a literal8 if
+// binary is set. sent is set once its content has been written to the server.
+type literal struct {
+	r      io.Reader
+	size   int64
+	binary bool
+	sent   bool
+}
+
+// errBroken is returned by the commands of a client whose connection was
+// closed after a command was cut.
+var errBroken = errors.New("connection is broken")
+
+// literalBody reads the content of a literal, with left bytes to go. It tells
+// a body shorter than its size or failing apart from a broken connection.
+type literalBody struct {
+	r    io.Reader
+	left int64
+}
+
+func (b *literalBody) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	b.left -= int64(n)
+	if err == io.EOF && b.left > 0 {
+		return n, fmt.Errorf("literal body is %d bytes short", b.left)
+	}
+	if err != nil && err != io.EOF {
+		return n, fmt.Errorf("read literal body: %s", err)
+	}
+	return n, err
+}
+
+// do sends a command made of parts, which are strings sent as they are or
+// *literal. A synchronizing literal waits for the server's continuation
+// request, and the command ends early if the server refuses it. Strings with
+// control characters, like a line break in a mailbox name, are refused since
+// they would end the command early.
+func (c *IMAPClient) do(parts ...interface{}) *Response {
+	return c.doThen(nil, parts...)
+}
+
+// doThen is do, calling then before another command can be sent if the
+// server answered OK. Its error becomes the command's.
+func (c *IMAPClient) doThen(then func() error, parts ...interface{}) *Response {
+	for _, part := range parts {
+		if str, ok := part.(string); ok && hasControl(str) {
+			ret := NewResponse()
+			ret.err = fmt.Errorf("invalid control character in command %q", str)
+			return ret
+		}
+	}
+	var literalPlus, literalMinus bool
+	for _, part := range parts {
+		if _, ok := part.(*literal); ok {
+			literalPlus = c.HasCapability("LITERAL+")
+			literalMinus = c.HasCapability("LITERAL-")
+			break
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	ret := NewResponse()
+	if c.broken {
+		ret.err = errBroken
+		return ret
+	}
+	c.touch()
+	c.count++
+	// The response gets its own copy, which an ENABLED reply adds to.
+	if c.enabled != nil {
+		ret.enabled = make(map[string]bool, len(c.enabled))
+		for cap := range c.enabled {
+			ret.enabled[cap] = true
+		}
+	}
+	line := fmt.Sprintf("a%03d ", c.count)
+	for _, part := range parts {
+		switch p := part.(type) {
+		case string:
+			line += p
+		case *literal:
+			nonSync := literalPlus || (literalMinus && p.size <= 4096)
+			if p.binary {
+				line += "~"
+			}
+			if nonSync {
+				line += fmt.Sprintf("{%d+}\r\n", p.size)
+			} else {
+				line += fmt.Sprintf("{%d}\r\n", p.size)
+			}
+			if _, err := c.w.Write([]byte(line)); err != nil {
+				ret.err = err
+				return ret
+			}
+			line = ""
+			if !nonSync {
+				if err := c.flush(); err != nil {
+					ret.err = err
+					return ret
+				}
+				if err := c.read(ret); err != nil {
+					ret.err = err
+					return ret
+				}
+				if !ret.continued {
+					return ret
+				}
+				ret.continued = false
+			}
+			// The server waits for the rest of a cut literal and would
+			// read the next command as its content, so the connection
+			// can't be used any more.
+			if _, err := io.CopyN(c.w, &literalBody{r: p.r, left: p.size}, p.size); err != nil {
+				c.broken = true
+				c.conn.Close()
+				ret.err = err
+				return ret
+			}
+			p.sent = true
+		}
+	}
 
-	_, err := c.conn.Write([]byte(cmd))
+	_, err := c.w.Write([]byte(line + "\r\n"))
+	if err == nil {
+		err = c.flush()
+	}
 	if err != nil {
 		ret.err = err
 		return ret
 	}
+	if err := c.read(ret); err != nil {
+		ret.err = err
+	}
+	for cap := range ret.enabled {
+		c.enabled[cap] = true
+	}
+	if cmd, ok := parts[0].(string); ok {
+		c.route(cmd, ret)
+	}
+	if ret.err == nil && then != nil {
+		ret.err = then()
+	}
+	return ret
+}
 
+// read feeds resp until the server finishes it or asks for a continuation.
+// Bytes received after that are kept for the next read.
+func (c *IMAPClient) read(resp *Response) error {
 	for {
-		n, err := c.conn.Read(c.buf)
+		chunk, err := c.readChunk()
 		if err != nil {
-			ret.err = err
-			return ret
+			return err
 		}
-		isFinished, err := ret.Feed(c.buf[:n])
+		isFinished, err := resp.Feed(chunk)
 		if err != nil {
-			ret.err = err
-			return ret
+			return err
 		}
 		if isFinished {
-			break
+			c.keep(chunk[resp.consumed:])
+			return nil
 		}
 	}
-	return ret
+}
+
+// readChunk returns the bytes kept by the last read, or reads new ones.
+func (c *IMAPClient) readChunk() ([]byte, error) {
+	if len(c.pending) > 0 {
+		ret := c.pending
+		c.pending = nil
+		return ret, nil
+	}
+	n, err := c.r.Read(c.buf)
+	return c.buf[:n], err
+}
+
+func (c *IMAPClient) keep(rest []byte) {
+	if len(rest) > 0 {
+		c.pending = append([]byte(nil), rest...)
+	}
+}
+
+// flush pushes out what was written, before waiting for the server.
+func (c *IMAPClient) flush() error {
+	if c.deflate == nil {
+		return nil
+	}
+	return c.deflate.Flush()
+}
+
+// Compress turns on COMPRESS=DEFLATE (RFC 4978). Everything sent and received
+// afterwards goes through deflate streams, which are flushed whenever the
+// client waits for the server.
+func (c *IMAPClient) Compress() error {
+	if c.deflate != nil {
+		return errors.New("compression is already on")
+	}
+	// The streams are installed before the keepalive loop can send a NOOP.
+	resp := c.doThen(func() error {
+		w, err := flate.NewWriter(c.w, flate.DefaultCompression)
+		if err != nil {
+			return err
+		}
+		c.deflate = w
+		c.w = w
+		c.r = flate.NewReader(io.MultiReader(bytes.NewReader(c.pending), c.r))
+		c.pending = nil
+		return nil
+	}, "COMPRESS DEFLATE")
+	return resp.Error()
+}
+
+// Capability returns the capabilities the server announces.
+func (c *IMAPClient) Capability() ([]string, error) {
+	resp := c.Do("CAPABILITY")
+	if resp.Error() != nil {
+		return nil, resp.Error()
+	}
+	ret := make([]string, 0)
+	c.caps = make(map[string]bool)
+	for _, reply := range resp.Replys() {
+		words := strings.Fields(reply.Origin())
+		if len(words) == 0 || strings.ToUpper(words[0]) != "CAPABILITY" {
+			continue
+		}
+		for _, cap := range words[1:] {
+			ret = append(ret, cap)
+			c.caps[strings.ToUpper(cap)] = true
+		}
+	}
+	return ret, nil
+}
+
+// HasCapability reports whether the server announces cap, like "UIDPLUS". The
+// capabilities are asked once and cached until the next Login.
+func (c *IMAPClient) HasCapability(cap string) bool {
+	if c.caps == nil {
+		if _, err := c.Capability(); err != nil {
+			return false
+		}
+	}
+	return c.caps[strings.ToUpper(cap)]
 }
 
 func (c *IMAPClient) Login(user, password string) error {
 	resp := c.Do(fmt.Sprintf("LOGIN %s %s", user, password))
+	c.caps = nil
+	if code, args := responseCode(resp.Status()); code == "CAPABILITY" {
+		c.caps = make(map[string]bool)
+		for _, arg := range args {
+			if cap, ok := arg.(string); ok {
+				c.caps[strings.ToUpper(cap)] = true
+			}
+		}
+	}
 	return resp.err
 }
 
+// Enable turns on the given extensions (RFC 5161), like CONDSTORE, QRESYNC or
+// UTF8=ACCEPT, and returns the ones the server actually enabled. Enabled
+// extensions change how commands are sent and responses are read.
+func (c *IMAPClient) Enable(caps ...string) ([]string, error) {
+	if !c.HasCapability("ENABLE") {
+		return nil, errors.New("server doesn't support ENABLE")
+	}
+	resp := c.Do(fmt.Sprintf("ENABLE %s", strings.Join(caps, " ")))
+	if resp.Error() != nil {
+		return nil, resp.Error()
+	}
+	ret := make([]string, 0)
+	for _, reply := range resp.Replys() {
+		words := strings.Fields(reply.Origin())
+		if len(words) == 0 || strings.ToUpper(words[0]) != "ENABLED" {
+			continue
+		}
+		for _, cap := range words[1:] {
+			ret = append(ret, strings.ToUpper(cap))
+		}
+	}
+	return ret, nil
+}
+
+// Enabled reports whether cap was turned on with Enable.
+func (c *IMAPClient) Enabled(cap string) bool {
+	return c.enabled[strings.ToUpper(cap)]
+}
+
 func (c *IMAPClient) Select(box string) *Response {
-	return c.Do(fmt.Sprintf("SELECT %s", box))
+	resp := c.Do(fmt.Sprintf("SELECT %s", c.mailboxName(box)))
+	c.selected = nil
+	if resp.Error() == nil {
+		if data, err := parseSelect(resp); err == nil {
+			c.selected = &selection{box, false, data.UIDValidity}
+		}
+	}
+	return resp
 }
 
 func (c *IMAPClient) Search(flag string) ([]string, error) {
-	resp := c.Do(fmt.Sprintf("SEARCH %s", flag))
+	return parseSearch(c.Do(fmt.Sprintf("SEARCH %s", flag)))
+}
+
+func parseSearch(resp *Response) ([]string, error) {
 	if resp.Error() != nil {
 		return nil, resp.Error()
 	}
@@ -134,9 +434,10 @@ func (c *IMAPClient) Fetch(id, arg string) (string, error) {
 	return "", errors.New("Invalid response")
 }
 
-func (c *IMAPClient) StoreFlag(id, flag string) error {
-	resp := c.Do(fmt.Sprintf("STORE %s FLAGS %s", id, flag))
-	return resp.Error()
+// StoreFlag adds flag to the message id, keeping its other flags.
+func (c *IMAPClient) StoreFlag(id string, flag Flag) error {
+	_, err := c.Store(id, StoreAdd, true, flag)
+	return err
 }
 
 func (c *IMAPClient) Logout() error {
@@ -169,6 +470,26 @@ func (c *IMAPClient) GetMessage(id string) (*mail.Message, error) {
 	}, nil
 }
 
+// hasControl reports whether str holds a control character.
+func hasControl(str string) bool {
+	for i := 0; i < len(str); i++ {
+		if str[i] < 0x20 || str[i] == 0x7f {
+			return true
+		}
+	}
+	return false
+}
+
+// quoteString makes str a quoted string to send as a command argument.
+func quoteString(str string) string {
+	str = strings.Replace(str, "\\", "\\\\", -1)
+	str = strings.Replace(str, "\"", "\\\"", -1)
+	return "\"" + str + "\""
+}
+
+// ParseAddress parses a list of addresses, like the From or To header.
+// Names may be encoded words (RFC 2047) or raw UTF-8 (RFC 6532); empty
+// entries are skipped.
 func ParseAddress(str string) ([]*mail.Address, error) {
 	inQuote := false
 	lastStart := 0
@@ -185,13 +506,17 @@ func ParseAddress(str string) ([]*mail.Address, error) {
 		}
 	}
 	strs = append(strs, str[lastStart:len(str)])
-	ret := make([]*mail.Address, len(strs), len(strs))
+	ret := make([]*mail.Address, 0, len(strs))
 	for i, s := range strs {
-		if s[len(s)-1] == '>' {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if s[len(s)-1] == '>' && strings.Contains(s, "<") {
 			split := strings.LastIndex(s, "<")
 			name := strings.Trim(s[:split], "\" ")
 			addr := s[split:]
-			if name[0] == '=' {
+			if strings.HasPrefix(name, "=?") {
 				data, charset, err := encodingex.DecodeEncodedWord(name)
 				if err != nil {
 					return nil, fmt.Errorf("address %d invalid: %s", i, err)
@@ -200,20 +525,20 @@ func ParseAddress(str string) ([]*mail.Address, error) {
 				if err != nil {
 					return nil, fmt.Errorf("address %d convert charset error: %s", i, err)
 				}
-				ret[i] = &mail.Address{
+				ret = append(ret, &mail.Address{
 					Name:    data,
 					Address: strings.Trim(addr, "<>"),
-				}
+				})
 			} else {
-				ret[i] = &mail.Address{
+				ret = append(ret, &mail.Address{
 					Name:    strings.Trim(name, "\""),
 					Address: strings.Trim(addr, "<>"),
-				}
+				})
 			}
 		} else {
-			ret[i] = &mail.Address{
+			ret = append(ret, &mail.Address{
 				Address: s,
-			}
+			})
 		}
 	}
 	return ret, nil
@@ -3,9 +3,17 @@ package imap
 import (
 	"bufio"
 	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
 	"net/mail"
 	"net/textproto"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestResponse(t *testing.T) {
@@ -289,3 +297,1420 @@ YWJjYWJjPGJyPjxicj4tLSA8YnI+5paw55qE55CG6K665LuO5bCR5pWw5Lq655qE5Li75byg5Yiw
 		}
 	}
 }
+
+func TestStoreResponse(t *testing.T) {
+	lines := []string{
+		"* 12 FETCH (FLAGS (\\Seen \\Flagged $Label1) UID 4827)\r\n",
+		"* 13 FETCH (UID 4828 FLAGS ())\r\n",
+		"a003 OK STORE completed\r\n",
+	}
+	resp := NewResponse()
+	for _, line := range lines {
+		resp.Feed([]byte(line))
+	}
+	if resp.Error() != nil {
+		t.Errorf("resp.Error should nil, got: %s", resp.Error())
+	}
+	datas, err := fetchReplies(resp)
+	if err != nil {
+		t.Fatalf("fetchReplies error: %s", err)
+	}
+	if len(datas) != 2 {
+		t.Fatalf("expect: 2, got: %d", len(datas))
+	}
+	if datas[0].SeqNum != 12 || datas[0].UID != 4827 {
+		t.Errorf("expect: 12 4827, got: %d %d", datas[0].SeqNum, datas[0].UID)
+	}
+	if !datas[0].Flags.Has(Seen) || !datas[0].Flags.Has("\\flagged") || !datas[0].Flags.Has("$Label1") {
+		t.Errorf("expect: (\\Seen \\Flagged $Label1), got: %s", datas[0].Flags)
+	}
+	if datas[0].Flags.Has(Deleted) {
+		t.Errorf("expect no \\Deleted, got: %s", datas[0].Flags)
+	}
+	if len(datas[1].Flags) != 0 {
+		t.Errorf("expect: (), got: %s", datas[1].Flags)
+	}
+}
+
+func TestReplyFields(t *testing.T) {
+	resp := NewResponse()
+	resp.Feed([]byte("* 1 FETCH (BODY[HEADER.FIELDS (SUBJECT)] {13}\r\nSubject: a)\r\n ENVELOPE (NIL \"say \\\"hi\\\"\"))\r\na001 OK done\r\n"))
+	if len(resp.Replys()) != 1 {
+		t.Fatalf("expect: 1, got: %d", len(resp.Replys()))
+	}
+	fields, err := resp.Replys()[0].Fields()
+	if err != nil {
+		t.Fatalf("Fields error: %s", err)
+	}
+	items := fields[2].([]interface{})
+	if items[0] != "BODY[HEADER.FIELDS (SUBJECT)]" {
+		t.Errorf("expect: BODY[HEADER.FIELDS (SUBJECT)], got: %v", items[0])
+	}
+	if items[1] != "Subject: a)\r\n" {
+		t.Errorf("expect: Subject: a), got: %q", items[1])
+	}
+	envelope := items[3].([]interface{})
+	if envelope[0] != nil || envelope[1] != "say \"hi\"" {
+		t.Errorf("expect: [nil say \"hi\"], got: %v", envelope)
+	}
+
+	resp = NewResponse()
+	resp.Feed([]byte("* OK [ALERT] Quota at 95% (almost full\r\n* OK Still here :)\r\n* BYE \"Bye\r\na001 OK done\r\n"))
+	for i, expect := range []string{"[ALERT] Quota at 95% (almost full", "Still here :)", "\"Bye"} {
+		fields, err := resp.Replys()[i].Fields()
+		if err != nil || len(fields) != 2 || fields[1] != expect {
+			t.Errorf("expect: %s, got: %v %v", expect, fields, err)
+		}
+	}
+}
+
+func TestSelectResponse(t *testing.T) {
+	lines := []string{
+		"* 172 EXISTS\r\n",
+		"* 1 RECENT\r\n",
+		"* OK [UNSEEN 12] Message 12 is first unseen\r\n",
+		"* OK [UIDVALIDITY 3857529045] UIDs valid\r\n",
+		"* OK [UIDNEXT 4392] Predicted next UID\r\n",
+		"* FLAGS (\\Answered \\Flagged \\Deleted \\Seen \\Draft)\r\n",
+		"* OK [PERMANENTFLAGS (\\Deleted \\Seen \\*)] Limited\r\n",
+		"* OK [HIGHESTMODSEQ 20010715194045007] Highest\r\n",
+		"* VANISHED (EARLIER) 41,43:45\r\n",
+		"* 49 FETCH (UID 117 FLAGS (\\Seen \\Answered) MODSEQ (90060115194045001))\r\n",
+		"a002 OK [READ-WRITE] SELECT completed\r\n",
+	}
+	resp := NewResponse()
+	resp.enabled = map[string]bool{"QRESYNC": true}
+	for _, line := range lines {
+		resp.Feed([]byte(line))
+	}
+	data, err := parseSelect(resp)
+	if err != nil {
+		t.Fatalf("parseSelect error: %s", err)
+	}
+	if data.Exists != 172 || data.Recent != 1 || data.Unseen != 12 {
+		t.Errorf("expect: 172 1 12, got: %d %d %d", data.Exists, data.Recent, data.Unseen)
+	}
+	if data.UIDValidity != 3857529045 || data.UIDNext != 4392 {
+		t.Errorf("expect: 3857529045 4392, got: %d %d", data.UIDValidity, data.UIDNext)
+	}
+	if data.HighestModSeq != 20010715194045007 {
+		t.Errorf("expect: 20010715194045007, got: %d", data.HighestModSeq)
+	}
+	if len(data.Flags) != 5 || len(data.PermanentFlags) != 3 || data.ReadOnly {
+		t.Errorf("expect: 5 flags, 3 permanent flags, read-write, got: %s %s %v", data.Flags, data.PermanentFlags, data.ReadOnly)
+	}
+	if data.Vanished.String() != "41,43:45" || data.Vanished.Len() != 4 {
+		t.Errorf("expect: 41,43:45, got: %v", data.Vanished)
+	}
+	if len(data.Changed) != 1 || data.Changed[0].UID != 117 || data.Changed[0].ModSeq != 90060115194045001 {
+		t.Errorf("expect: UID 117 MODSEQ 90060115194045001, got: %v", data.Changed)
+	}
+}
+
+func TestListResponse(t *testing.T) {
+	lines := []string{
+		"* LIST (\\HasNoChildren) \"/\" \"INBOX\"\r\n",
+		"* LIST (\\Noselect \\HasChildren) \"/\" \"[Gmail]\"\r\n",
+		"* LIST (\\HasNoChildren) NIL {9}\r\nSay \"hi\" \r\n",
+		"* OK Still here :)\r\n",
+		"a004 OK Success\r\n",
+	}
+	resp := NewResponse()
+	for _, line := range lines {
+		resp.Feed([]byte(line))
+	}
+	boxes, err := parseList(resp, "LIST")
+	if err != nil {
+		t.Fatalf("parseList error: %s", err)
+	}
+	if len(boxes) != 3 {
+		t.Fatalf("expect: 3, got: %d", len(boxes))
+	}
+	if boxes[0].Name != "INBOX" || boxes[0].Delimiter != "/" {
+		t.Errorf("expect: INBOX /, got: %s %s", boxes[0].Name, boxes[0].Delimiter)
+	}
+	if !boxes[1].HasAttribute(NoSelect) || !boxes[1].HasAttribute("\\haschildren") || boxes[1].Name != "[Gmail]" {
+		t.Errorf("expect: [Gmail] (\\Noselect \\HasChildren), got: %s %v", boxes[1].Name, boxes[1].Attributes)
+	}
+	if boxes[2].Name != "Say \"hi\" " || boxes[2].Delimiter != "" {
+		t.Errorf("expect: Say \"hi\" with no delimiter, got: %q %q", boxes[2].Name, boxes[2].Delimiter)
+	}
+	if quoteString("a\"b\\c") != "\"a\\\"b\\\\c\"" {
+		t.Errorf("expect: \"a\\\"b\\\\c\", got: %s", quoteString("a\"b\\c"))
+	}
+}
+
+func TestMailboxEncoding(t *testing.T) {
+	tests := []struct {
+		decoded string
+		encoded string
+	}{
+		{"INBOX", "INBOX"},
+		{"Entwürfe", "Entw&APw-rfe"},
+		{"~peter/mail/台北/日本語", "~peter/mail/&U,BTFw-/&ZeVnLIqe-"},
+		{"Tom & Jerry", "Tom &- Jerry"},
+		{"😀", "&2D3eAA-"},
+	}
+	for _, test := range tests {
+		if got := EncodeMailbox(test.decoded); got != test.encoded {
+			t.Errorf("encode %s expect: %s, got: %s", test.decoded, test.encoded, got)
+		}
+		got, err := DecodeMailbox(test.encoded)
+		if err != nil || got != test.decoded {
+			t.Errorf("decode %s expect: %s, got: %s %v", test.encoded, test.decoded, got, err)
+		}
+	}
+	for _, invalid := range []string{"&U,BTFw", "&AGE-", "Entwürfe", "&APw"} {
+		if _, err := DecodeMailbox(invalid); err == nil {
+			t.Errorf("decode %s expect error", invalid)
+		}
+	}
+}
+
+func TestListExtendedResponse(t *testing.T) {
+	lines := []string{
+		"* LIST (\\Subscribed \\HasChildren) \"/\" \"Foo\" (\"CHILDINFO\" (\"SUBSCRIBED\"))\r\n",
+		"* LIST (\\HasNoChildren \\Sent) \"/\" {9}\r\nSent Mail\r\n",
+		"* STATUS {9}\r\nSent Mail (MESSAGES 17 UNSEEN 16)\r\n",
+		"* LIST (\\NonExistent) \"/\" \"Bar\" (\"OLDNAME\" (\"Baz\"))\r\n",
+		"a005 OK LIST completed\r\n",
+	}
+	resp := NewResponse()
+	for _, line := range lines {
+		resp.Feed([]byte(line))
+	}
+	boxes, err := parseList(resp, "LIST")
+	if err != nil {
+		t.Fatalf("parseList error: %s", err)
+	}
+	if len(boxes) != 3 {
+		t.Fatalf("expect: 3, got: %d", len(boxes))
+	}
+	if len(boxes[0].ChildInfo) != 1 || boxes[0].ChildInfo[0] != "SUBSCRIBED" {
+		t.Errorf("expect: CHILDINFO SUBSCRIBED, got: %v", boxes[0].ChildInfo)
+	}
+	if !boxes[1].HasAttribute(UseSent) || boxes[1].Status == nil {
+		t.Fatalf("expect: \\Sent with status, got: %v %v", boxes[1].Attributes, boxes[1].Status)
+	}
+	if boxes[1].Status.Messages != 17 || boxes[1].Status.Unseen != 16 {
+		t.Errorf("expect: 17 16, got: %d %d", boxes[1].Status.Messages, boxes[1].Status.Unseen)
+	}
+	if boxes[2].OldName != "Baz" || boxes[2].Status != nil {
+		t.Errorf("expect: OLDNAME Baz without status, got: %s %v", boxes[2].OldName, boxes[2].Status)
+	}
+}
+
+func TestStatusResponse(t *testing.T) {
+	resp := NewResponse()
+	resp.Feed([]byte("* STATUS {10}\r\nA (Folder) (MESSAGES 231 UIDNEXT 44292 SIZE 8392204 HIGHESTMODSEQ 7011231777 DELETED 2)\r\na006 OK STATUS completed\r\n"))
+	fields, err := resp.Replys()[0].Fields()
+	if err != nil {
+		t.Fatalf("Fields error: %s", err)
+	}
+	status, err := parseStatus(fields)
+	if err != nil {
+		t.Fatalf("parseStatus error: %s", err)
+	}
+	if status.Name != "A (Folder)" {
+		t.Errorf("expect: A (Folder), got: %s", status.Name)
+	}
+	if status.Messages != 231 || status.UIDNext != 44292 || status.Deleted != 2 {
+		t.Errorf("expect: 231 44292 2, got: %d %d %d", status.Messages, status.UIDNext, status.Deleted)
+	}
+	if status.Size != 8392204 || status.HighestModSeq != 7011231777 {
+		t.Errorf("expect: 8392204 7011231777, got: %d %d", status.Size, status.HighestModSeq)
+	}
+}
+
+func TestAppendResponse(t *testing.T) {
+	resp := NewResponse()
+	finished, _ := resp.Feed([]byte("+ Ready for literal data\r\n"))
+	if !finished || !resp.continued {
+		t.Errorf("expect continuation, got: %v %v", finished, resp.continued)
+	}
+	resp.continued = false
+	resp.Feed([]byte("a007 OK [APPENDUID 38505 3955] APPEND completed\r\n"))
+	if resp.Error() != nil {
+		t.Fatalf("resp.Error should nil, got: %s", resp.Error())
+	}
+	uid, err := parseAppendUID(resp.Status())
+	if err != nil || uid == nil {
+		t.Fatalf("parseAppendUID error: %v", err)
+	}
+	if uid.UIDValidity != 38505 || uid.UIDs.String() != "3955" {
+		t.Errorf("expect: 38505 [3955], got: %d %v", uid.UIDValidity, uid.UIDs)
+	}
+
+	uid, err = parseAppendUID("OK [APPENDUID 38505 3955:3957] MULTIAPPEND completed")
+	if err != nil || uid == nil || uid.UIDs.String() != "3955:3957" {
+		t.Errorf("expect: 3955:3957, got: %v %v", uid, err)
+	}
+
+	resp = NewResponse()
+	resp.Feed([]byte("a008 NO [TRYCREATE] Mailbox doesn't exist\r\n"))
+	e, ok := resp.Error().(*ResponseError)
+	if !ok || e.Code != "TRYCREATE" {
+		t.Errorf("expect: TRYCREATE error, got: %v", resp.Error())
+	}
+}
+
+func TestMoveResponse(t *testing.T) {
+	lines := []string{
+		"* OK [COPYUID 432432 42:43 1202:1203] Moved\r\n",
+		"* 22 EXPUNGE\r\n",
+		"* 22 EXPUNGE\r\n",
+		"a009 OK Done\r\n",
+	}
+	resp := NewResponse()
+	for _, line := range lines {
+		resp.Feed([]byte(line))
+	}
+	uid, err := parseCopyUID(resp)
+	if err != nil || uid == nil {
+		t.Fatalf("parseCopyUID error: %v", err)
+	}
+	if uid.UIDValidity != 432432 || uid.Source.String() != "42:43" || uid.Dest.String() != "1202:1203" {
+		t.Errorf("expect: 432432 42:43 1202:1203, got: %d %v %v", uid.UIDValidity, uid.Source, uid.Dest)
+	}
+	if dest, ok := uid.DestOf(43); !ok || dest != 1203 {
+		t.Errorf("expect: 1203, got: %d %v", dest, ok)
+	}
+	if _, ok := uid.DestOf(44); ok {
+		t.Errorf("expect: 44 not copied")
+	}
+	ids, err := expunged(resp)
+	if err != nil || fmt.Sprint(ids.SeqNums) != "[22 22]" || len(ids.UIDs) != 0 {
+		t.Errorf("expect: [22 22], got: %v %v", ids, err)
+	}
+
+	resp = NewResponse()
+	resp.enabled = map[string]bool{"QRESYNC": true}
+	resp.Feed([]byte("* VANISHED 405,407:410\r\na010 OK Done\r\n"))
+	ids, err = expunged(resp)
+	if err != nil || len(ids.SeqNums) != 0 || ids.UIDs.String() != "405,407:410" {
+		t.Errorf("expect: UIDs 405,407:410, got: %v %v", ids, err)
+	}
+
+	set, err := parseSeqSet("1:4000000000,4000000002")
+	if err != nil || len(set) != 2 || set.Len() != 4000000001 {
+		t.Errorf("expect: 4000000001 UIDs in 2 ranges, got: %v %v", set, err)
+	}
+	if !set.Contains(3999999999) || set.Contains(4000000001) {
+		t.Errorf("expect: 3999999999 but not 4000000001 in %s", set)
+	}
+}
+
+// newPipeClient returns a client without capabilities talking to server
+// through a pipe.
+func newPipeClient(server func(conn net.Conn)) *IMAPClient {
+	client, conn := net.Pipe()
+	go server(conn)
+	return &IMAPClient{
+		conn:    client,
+		r:       client,
+		w:       client,
+		buf:     make([]byte, 1024),
+		caps:    make(map[string]bool),
+		enabled: make(map[string]bool),
+	}
+}
+
+// readCommand reads a command from the client, with its LITERAL+ literals.
+func readCommand(reader *bufio.Reader) string {
+	ret := ""
+	for {
+		line, err := reader.ReadString('\n')
+		ret += line
+		if err != nil {
+			return ret
+		}
+		var size int
+		i := strings.LastIndex(line, "{")
+		if i < 0 || !strings.HasSuffix(line, "+}\r\n") {
+			return ret
+		}
+		if _, err := fmt.Sscanf(line[i:], "{%d+}", &size); err != nil {
+			return ret
+		}
+		literal := make([]byte, size)
+		n, _ := io.ReadFull(reader, literal)
+		ret += string(literal[:n])
+	}
+}
+
+func TestAppend(t *testing.T) {
+	commands := make(chan string, 5)
+	client := newPipeClient(func(conn net.Conn) {
+		reader := bufio.NewReader(conn)
+		commands <- readCommand(reader)
+		conn.Write([]byte("a001 OK [APPENDUID 38505 3955:3956] APPEND completed\r\n"))
+		commands <- readCommand(reader)
+		conn.Write([]byte("a002 OK [APPENDUID 38505 3957] APPEND completed\r\n"))
+		commands <- readCommand(reader)
+		conn.Write([]byte("a003 OK [APPENDUID 38505 3958] APPEND completed\r\n"))
+		commands <- readCommand(reader)
+		conn.Write([]byte("a004 NO [OVERQUOTA] Quota exceeded\r\n"))
+		commands <- readCommand(reader)
+	})
+	client.caps["LITERAL+"] = true
+	client.caps["MULTIAPPEND"] = true
+	date := time.Date(2020, 2, 3, 4, 5, 6, 0, time.UTC)
+	uid, err := client.AppendMulti(Inbox, []*AppendMessage{
+		{Flags: []Flag{Seen}, Date: date, Body: bytes.NewBufferString("abc"), Size: 3},
+		{Body: bytes.NewBufferString("de"), Size: 2},
+	})
+	if err != nil {
+		t.Fatalf("AppendMulti error: %s", err)
+	}
+	if line := <-commands; line != "a001 APPEND \"INBOX\" (\\Seen) \"03-Feb-2020 04:05:06 +0000\" {3+}\r\nabc {2+}\r\nde\r\n" {
+		t.Errorf("unexpected command: %q", line)
+	}
+	if uid == nil || uid.UIDs.String() != "3955:3956" {
+		t.Errorf("expect: 3955:3956, got: %v", uid)
+	}
+
+	_, err = client.AppendMulti(Inbox, []*AppendMessage{{Parts: []CatenatePart{
+		{URL: "/INBOX;UIDVALIDITY=38505/;UID=20/;SECTION=1.MIME"},
+		{Text: bytes.NewBufferString("hello"), Size: 5},
+	}}})
+	if err != nil {
+		t.Fatalf("AppendMulti error: %s", err)
+	}
+	if line := <-commands; line != "a002 APPEND \"INBOX\" CATENATE (URL \"/INBOX;UIDVALIDITY=38505/;UID=20/;SECTION=1.MIME\" TEXT {5+}\r\nhello)\r\n" {
+		t.Errorf("unexpected command: %q", line)
+	}
+
+	client.caps["MULTIAPPEND"] = false
+	uid, err = client.AppendMulti(Inbox, []*AppendMessage{
+		{Body: bytes.NewBufferString("f"), Size: 1},
+		{Body: bytes.NewBufferString("g"), Size: 1},
+	})
+	if line := <-commands; line != "a003 APPEND \"INBOX\" {1+}\r\nf\r\n" {
+		t.Errorf("unexpected command: %q", line)
+	}
+	if line := <-commands; line != "a004 APPEND \"INBOX\" {1+}\r\ng\r\n" {
+		t.Errorf("unexpected command: %q", line)
+	}
+	if e, ok := err.(*ResponseError); !ok || e.Code != "OVERQUOTA" {
+		t.Errorf("expect: OVERQUOTA error, got: %v", err)
+	}
+	if uid == nil || uid.UIDs.String() != "3958" {
+		t.Errorf("expect: 3958 appended before the error, got: %v", uid)
+	}
+
+	_, err = client.Append(Inbox, nil, time.Time{}, bytes.NewBufferString("abc"), 10)
+	if err == nil || isConnError(err) {
+		t.Errorf("expect: short body error, got: %v", err)
+	}
+	if line := <-commands; line != "a005 APPEND \"INBOX\" {10+}\r\nabc" {
+		t.Errorf("unexpected command: %q", line)
+	}
+	if err := client.Noop(); err != errBroken {
+		t.Errorf("expect: %v, got: %v", errBroken, err)
+	}
+}
+
+func TestMoveFallback(t *testing.T) {
+	commands := make(chan string, 3)
+	client := newPipeClient(func(conn net.Conn) {
+		reader := bufio.NewReader(conn)
+		line, _ := reader.ReadString('\n')
+		commands <- line
+		conn.Write([]byte("a001 OK [COPYUID 38505 500:502 1000:1002] COPY completed\r\n"))
+		line, _ = reader.ReadString('\n')
+		commands <- line
+		conn.Write([]byte("a002 OK STORE completed\r\n"))
+		line, _ = reader.ReadString('\n')
+		commands <- line
+		conn.Write([]byte("* 3 EXPUNGE\r\n* 3 EXPUNGE\r\n* 3 EXPUNGE\r\na003 OK UID EXPUNGE completed\r\n"))
+	})
+	client.caps["UIDPLUS"] = true
+	uid, err := client.UIDMove("500:502", "Archive")
+	if err != nil {
+		t.Fatalf("UIDMove error: %s", err)
+	}
+	expects := []string{
+		"a001 UID COPY 500:502 \"Archive\"\r\n",
+		"a002 UID STORE 500:502 +FLAGS.SILENT (\\Deleted)\r\n",
+		"a003 UID EXPUNGE 500:502\r\n",
+	}
+	for _, expect := range expects {
+		if line := <-commands; line != expect {
+			t.Errorf("expect: %q, got: %q", expect, line)
+		}
+	}
+	if uid == nil || uid.Dest.String() != "1000:1002" {
+		t.Errorf("expect: 1000:1002, got: %v", uid)
+	}
+}
+
+func TestKeepalive(t *testing.T) {
+	commands := make(chan string, 10)
+	client := newPipeClient(func(conn net.Conn) {
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				close(commands)
+				return
+			}
+			commands <- line
+			words := strings.Fields(line)
+			switch words[1] {
+			case "NOOP":
+				conn.Write([]byte("* 4 EXISTS\r\n" + words[0] + " OK NOOP completed\r\n"))
+			case "CHECK":
+				conn.Write([]byte(words[0] + " OK CHECK completed\r\n"))
+			case "IDLE":
+				conn.Write([]byte("+ idling\r\n"))
+			}
+		}
+	})
+	updates := client.Updates()
+	if err := client.Noop(); err != nil {
+		t.Fatalf("Noop error: %s", err)
+	}
+	if line := <-commands; line != "a001 NOOP\r\n" {
+		t.Errorf("unexpected command: %q", line)
+	}
+	if update := <-updates; update.Origin() != "4 EXISTS" {
+		t.Errorf("expect: 4 EXISTS, got: %s", update.Origin())
+	}
+	if err := client.Check(); err != nil {
+		t.Fatalf("Check error: %s", err)
+	}
+	if line := <-commands; line != "a002 CHECK\r\n" {
+		t.Errorf("unexpected command: %q", line)
+	}
+
+	client.StartKeepalive(10 * time.Millisecond)
+	if line := <-commands; line != "a003 NOOP\r\n" {
+		t.Errorf("unexpected command: %q", line)
+	}
+	client.StopKeepalive()
+	client.StartKeepalive(0)
+	time.Sleep(20 * time.Millisecond)
+
+	idleErr := make(chan error, 1)
+	go func() {
+		idleErr <- client.Idle(make(chan struct{}))
+	}()
+	noops := 0
+	for line := range commands {
+		if strings.HasSuffix(line, "IDLE\r\n") {
+			break
+		}
+		noops++
+	}
+	// One NOOP may have been sent before StopKeepalive.
+	if noops > 1 {
+		t.Errorf("expect: no keepalive with interval 0, got: %d NOOPs", noops)
+	}
+	closed := make(chan error, 1)
+	go func() {
+		closed <- client.Close()
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatalf("Close waited for Idle")
+	}
+	select {
+	case err := <-idleErr:
+		if err == nil {
+			t.Errorf("expect: Idle aborted by Close")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Idle didn't return after Close")
+	}
+}
+
+func TestCompress(t *testing.T) {
+	commands := make(chan string, 2)
+	client := newPipeClient(func(conn net.Conn) {
+		reader := bufio.NewReader(conn)
+		line, _ := reader.ReadString('\n')
+		commands <- line
+		conn.Write([]byte("a001 OK DEFLATE active\r\n"))
+
+		r := bufio.NewReader(flate.NewReader(reader))
+		w, _ := flate.NewWriter(conn, flate.BestCompression)
+		line, _ = r.ReadString('\n')
+		commands <- line
+		w.Write([]byte("* 3 EXISTS\r\na002 OK NOOP completed\r\n"))
+		w.Flush()
+	})
+	if err := client.Compress(); err != nil {
+		t.Fatalf("Compress error: %s", err)
+	}
+	if line := <-commands; line != "a001 COMPRESS DEFLATE\r\n" {
+		t.Errorf("expect: a001 COMPRESS DEFLATE, got: %q", line)
+	}
+	resp := client.Do("NOOP")
+	if resp.Error() != nil {
+		t.Fatalf("NOOP error: %s", resp.Error())
+	}
+	if line := <-commands; line != "a002 NOOP\r\n" {
+		t.Errorf("expect: a002 NOOP, got: %q", line)
+	}
+	if len(resp.Replys()) != 1 || resp.Replys()[0].Origin() != "3 EXISTS" {
+		t.Errorf("expect: 3 EXISTS, got: %v", resp.Replys())
+	}
+}
+
+func TestID(t *testing.T) {
+	commands := make(chan string, 1)
+	client := newPipeClient(func(conn net.Conn) {
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		commands <- line
+		conn.Write([]byte("* ID (\"name\" \"Cyrus\" \"version\" \"1.5\" \"os\" NIL)\r\na001 OK Success\r\n"))
+	})
+	fields, err := client.ID(map[string]string{"version": "1.0", "name": "goimap"})
+	if err != nil {
+		t.Fatalf("ID error: %s", err)
+	}
+	if line := <-commands; line != "a001 ID (\"name\" \"goimap\" \"version\" \"1.0\")\r\n" {
+		t.Errorf("expect: a001 ID (\"name\" \"goimap\" \"version\" \"1.0\"), got: %q", line)
+	}
+	if len(fields) != 3 || fields["name"] != "Cyrus" || fields["version"] != "1.5" || fields["os"] != "" {
+		t.Errorf("expect: name Cyrus version 1.5 os empty, got: %v", fields)
+	}
+}
+
+func TestEnable(t *testing.T) {
+	client := newPipeClient(func(conn net.Conn) {
+		reader := bufio.NewReader(conn)
+		reader.ReadString('\n')
+		conn.Write([]byte("a001 OK NOOP completed\r\n"))
+		reader.ReadString('\n')
+		conn.Write([]byte("* ENABLED QRESYNC\r\na002 OK Enabled\r\n"))
+		reader.ReadString('\n')
+		conn.Write([]byte("* VANISHED 405,407\r\na003 OK Expunged\r\n"))
+	})
+	client.caps["ENABLE"] = true
+	before := client.Do("NOOP")
+	caps, err := client.Enable("QRESYNC", "X-UNKNOWN")
+	if err != nil {
+		t.Fatalf("Enable error: %s", err)
+	}
+	if len(caps) != 1 || caps[0] != "QRESYNC" {
+		t.Errorf("expect: [QRESYNC], got: %v", caps)
+	}
+	if !client.Enabled("qresync") || !client.Enabled("CONDSTORE") || client.Enabled("X-UNKNOWN") {
+		t.Errorf("expect: QRESYNC and CONDSTORE enabled, got: %v", client.enabled)
+	}
+	if before.Enabled("QRESYNC") {
+		t.Errorf("expect: QRESYNC not enabled when NOOP was read")
+	}
+	ids, err := client.Expunge()
+	if err != nil || ids.UIDs.String() != "405,407" || len(ids.SeqNums) != 0 {
+		t.Errorf("expect: UIDs 405,407, got: %v %v", ids, err)
+	}
+}
+
+func TestNamespaceResponse(t *testing.T) {
+	resp := NewResponse()
+	resp.Feed([]byte("* NAMESPACE ((\"\" \"/\")) ((\"~\" \"/\")) ((\"#shared/\" \"/\" \"X-PARAM\" (\"FLAG1\")) (\"#public/\" NIL))\r\na010 OK done\r\n"))
+	ns, err := parseNamespace(resp)
+	if err != nil {
+		t.Fatalf("parseNamespace error: %s", err)
+	}
+	if len(ns.Personal) != 1 || ns.Personal[0] != (Namespace{"", "/"}) {
+		t.Errorf("expect: [{ /}], got: %v", ns.Personal)
+	}
+	if len(ns.OtherUsers) != 1 || ns.OtherUsers[0] != (Namespace{"~", "/"}) {
+		t.Errorf("expect: [{~ /}], got: %v", ns.OtherUsers)
+	}
+	if len(ns.Shared) != 2 || ns.Shared[0] != (Namespace{"#shared/", "/"}) || ns.Shared[1] != (Namespace{"#public/", ""}) {
+		t.Errorf("expect: [{#shared/ /} {#public/ }], got: %v", ns.Shared)
+	}
+
+	resp = NewResponse()
+	resp.Feed([]byte("* NAMESPACE ((\"INBOX.\" \".\")) NIL NIL\r\na011 OK done\r\n"))
+	ns, err = parseNamespace(resp)
+	if err != nil || len(ns.OtherUsers) != 0 || len(ns.Shared) != 0 {
+		t.Errorf("expect: no other users and shared namespaces, got: %v %v", ns, err)
+	}
+}
+
+func TestQuotaResponse(t *testing.T) {
+	resp := NewResponse()
+	resp.Feed([]byte("* QUOTAROOT INBOX \"\" \"#user/alice\"\r\n* QUOTA \"\" (STORAGE 10 512 MESSAGE 8 1000)\r\na012 OK Getquotaroot completed\r\n"))
+	roots, quotas, err := parseQuota(resp)
+	if err != nil {
+		t.Fatalf("parseQuota error: %s", err)
+	}
+	if fmt.Sprint(roots) != "[ #user/alice]" {
+		t.Errorf("expect: [ #user/alice], got: %v", roots)
+	}
+	if len(quotas) != 1 || quotas[0].Root != "" {
+		t.Fatalf("expect: 1 quota of root \"\", got: %v", quotas)
+	}
+	storage := quotas[0].Resource(QuotaStorage)
+	if storage == nil || storage.Usage != 10 || storage.Limit != 512 {
+		t.Errorf("expect: STORAGE 10 512, got: %v", storage)
+	}
+	if quotas[0].Resource(QuotaMailbox) != nil {
+		t.Errorf("expect no MAILBOX limit, got: %v", quotas[0].Resource(QuotaMailbox))
+	}
+
+	resp = NewResponse()
+	resp.Feed([]byte("a013 NO [OVERQUOTA] Quota exceeded\r\n"))
+	if !IsOverQuota(resp.Error()) || IsOverQuota(errors.New("NO [OVERQUOTA]")) {
+		t.Errorf("expect: over quota, got: %v", resp.Error())
+	}
+}
+
+func TestACL(t *testing.T) {
+	client := newPipeClient(func(conn net.Conn) {
+		reader := bufio.NewReader(conn)
+		reader.ReadString('\n')
+		conn.Write([]byte("* ACL INBOX Fred rwipslxetad \"Team Lead\" lr\r\na001 OK Getacl complete\r\n"))
+		reader.ReadString('\n')
+		conn.Write([]byte("* LISTRIGHTS ~/Mail/saved smith la r swicdkxte\r\na002 OK Listrights completed\r\n"))
+	})
+	acl, err := client.GetACL(Inbox)
+	if err != nil {
+		t.Fatalf("GetACL error: %s", err)
+	}
+	if len(acl) != 2 || !acl["Fred"].Has(RightAdminister) || !acl["Team Lead"].Has(RightLookup) || acl["Team Lead"].Has(RightWrite) {
+		t.Errorf("expect: Fred rwipslxetad, Team Lead lr, got: %v", acl)
+	}
+	required, optional, err := client.ListRights("~/Mail/saved", "smith")
+	if err != nil {
+		t.Fatalf("ListRights error: %s", err)
+	}
+	if required != "la" || len(optional) != 2 || optional[0] != "r" || optional[1] != "swicdkxte" {
+		t.Errorf("expect: la [r swicdkxte], got: %s %v", required, optional)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	commands := make(chan string, 3)
+	client := newPipeClient(func(conn net.Conn) {
+		reader := bufio.NewReader(conn)
+		line, _ := reader.ReadString('\n')
+		commands <- line
+		conn.Write([]byte("* METADATA \"INBOX\" (/private/comment {11}\r\nMy\r\ncomment /shared/vendor/color NIL)\r\n"))
+		conn.Write([]byte("a001 OK [METADATA LONGENTRIES 2199] GETMETADATA complete\r\n"))
+
+		line, _ = reader.ReadString('\n')
+		commands <- line
+		conn.Write([]byte("+ go ahead\r\n"))
+		literal := make([]byte, 7)
+		io.ReadFull(reader, literal)
+		line, _ = reader.ReadString('\n')
+		commands <- string(literal) + line
+		conn.Write([]byte("a002 OK SETMETADATA complete\r\n"))
+	})
+	entries, longest, err := client.GetMetadata(Inbox, []string{"/private/comment", "/shared/vendor/color"}, &MetadataOptions{MaxSize: 1024, Depth: "1"})
+	if err != nil {
+		t.Fatalf("GetMetadata error: %s", err)
+	}
+	if line := <-commands; line != "a001 GETMETADATA (MAXSIZE 1024 DEPTH 1) \"INBOX\" (\"/private/comment\" \"/shared/vendor/color\")\r\n" {
+		t.Errorf("unexpected command: %q", line)
+	}
+	if len(entries) != 1 || entries["/private/comment"] != "My\r\ncomment" || longest != 2199 {
+		t.Errorf("expect: /private/comment My\\r\\ncomment and 2199, got: %q %d", entries, longest)
+	}
+
+	err = client.SetMetadata(Inbox, map[string]string{"/private/comment": "a\r\nb\r\nc", "/private/color": ""})
+	if err != nil {
+		t.Fatalf("SetMetadata error: %s", err)
+	}
+	if line := <-commands; line != "a002 SETMETADATA \"INBOX\" (\"/private/color\" NIL \"/private/comment\" {7}\r\n" {
+		t.Errorf("unexpected command: %q", line)
+	}
+	if line := <-commands; line != "a\r\nb\r\nc)\r\n" {
+		t.Errorf("unexpected literal: %q", line)
+	}
+}
+
+func TestGmail(t *testing.T) {
+	commands := make(chan string, 2)
+	client := newPipeClient(func(conn net.Conn) {
+		reader := bufio.NewReader(conn)
+		line, _ := reader.ReadString('\n')
+		commands <- line
+		conn.Write([]byte("* 1 FETCH (X-GM-THRID 1278455344230334865 X-GM-MSGID 1278455344230334866 X-GM-LABELS (\\Inbox \\Sent Important \"Muy Importante\" Entw&APw-rfe) UID 4)\r\n"))
+		conn.Write([]byte("a001 OK FETCH (Success)\r\n"))
+		line, _ = reader.ReadString('\n')
+		commands <- line
+		conn.Write([]byte("* SEARCH 123 12344 5992\r\na002 OK SEARCH (Success)\r\n"))
+	})
+	datas, err := client.FetchItems("1", GmailThreadID, GmailMsgID, GmailLabels)
+	if err != nil {
+		t.Fatalf("FetchItems error: %s", err)
+	}
+	if line := <-commands; line != "a001 FETCH 1 (X-GM-THRID X-GM-MSGID X-GM-LABELS)\r\n" {
+		t.Errorf("unexpected command: %q", line)
+	}
+	if len(datas) != 1 || datas[0].GmailThreadID != 1278455344230334865 || datas[0].GmailMsgID != 1278455344230334866 {
+		t.Fatalf("expect: thread 1278455344230334865 message 1278455344230334866, got: %v", datas)
+	}
+	if fmt.Sprint(datas[0].GmailLabels) != "[\\Inbox \\Sent Important Muy Importante Entwürfe]" {
+		t.Errorf("unexpected labels: %q", datas[0].GmailLabels)
+	}
+	ids, err := client.GmailSearch("has:attachment in:unread")
+	if err != nil {
+		t.Fatalf("GmailSearch error: %s", err)
+	}
+	if line := <-commands; line != "a002 SEARCH X-GM-RAW \"has:attachment in:unread\"\r\n" {
+		t.Errorf("unexpected command: %q", line)
+	}
+	if fmt.Sprint(ids) != "[123 12344 5992]" {
+		t.Errorf("expect: [123 12344 5992], got: %v", ids)
+	}
+}
+
+func TestNotifyIdle(t *testing.T) {
+	commands := make(chan string, 6)
+	client := newPipeClient(func(conn net.Conn) {
+		reader := bufio.NewReader(conn)
+		line, _ := reader.ReadString('\n')
+		commands <- line
+		conn.Write([]byte("* STATUS INBOX (MESSAGES 3 UIDNEXT 4)\r\na001 OK NOTIFY completed\r\n"))
+		line, _ = reader.ReadString('\n')
+		commands <- line
+		conn.Write([]byte("+ idling\r\n* STATUS Archive (MESSAGES 8 UIDNEXT 9)\r\n"))
+		line, _ = reader.ReadString('\n')
+		commands <- line
+		conn.Write([]byte("a002 OK IDLE terminated\r\n"))
+		line, _ = reader.ReadString('\n')
+		commands <- line
+		conn.Write([]byte("* 1 FETCH (UID 7)\r\n* 3 EXISTS\r\n* LIST () \"/\" New\r\na003 OK FETCH completed\r\n"))
+		line, _ = reader.ReadString('\n')
+		commands <- line
+		conn.Write([]byte("+ idling\r\na004 OK IDLE timed out\r\n"))
+		line, _ = reader.ReadString('\n')
+		commands <- line
+		conn.Write([]byte("a005 OK NOOP completed\r\n"))
+	})
+	updates := client.Updates()
+	err := client.Notify(true,
+		NotifyGroup{Mailboxes: NotifySelected, Events: []string{"MessageNew (UID)", EventMessageExpunge}},
+		NotifyGroup{Mailboxes: NotifyMailboxes, Names: []string{Inbox, "Archive"}, Events: []string{EventMessageNew}},
+		NotifyGroup{Mailboxes: NotifySubscribed})
+	if err != nil {
+		t.Fatalf("Notify error: %s", err)
+	}
+	if line := <-commands; line != "a001 NOTIFY SET STATUS (SELECTED (MessageNew (UID) MessageExpunge)) (MAILBOXES (\"INBOX\" \"Archive\") (MessageNew)) (SUBSCRIBED NONE)\r\n" {
+		t.Errorf("unexpected command: %q", line)
+	}
+	if update := <-updates; update.Origin() != "STATUS INBOX (MESSAGES 3 UIDNEXT 4)" {
+		t.Errorf("unexpected update: %s", update.Origin())
+	}
+
+	stop := make(chan struct{})
+	idled := make(chan error)
+	go func() {
+		idled <- client.Idle(stop)
+	}()
+	if line := <-commands; line != "a002 IDLE\r\n" {
+		t.Errorf("unexpected command: %q", line)
+	}
+	if update := <-updates; update.Origin() != "STATUS Archive (MESSAGES 8 UIDNEXT 9)" {
+		t.Errorf("unexpected update: %s", update.Origin())
+	}
+	close(stop)
+	if line := <-commands; line != "DONE\r\n" {
+		t.Errorf("unexpected command: %q", line)
+	}
+	if err := <-idled; err != nil {
+		t.Errorf("Idle error: %s", err)
+	}
+
+	datas, err := client.FetchItems("1", "UID")
+	if err != nil {
+		t.Fatalf("FetchItems error: %s", err)
+	}
+	<-commands
+	if len(datas) != 1 || datas[0].UID != 7 {
+		t.Errorf("expect: UID 7 only, got: %v", datas)
+	}
+	for _, expect := range []string{"3 EXISTS", "LIST () \"/\" New"} {
+		if update := <-updates; update.Origin() != expect {
+			t.Errorf("expect: %s, got: %s", expect, update.Origin())
+		}
+	}
+
+	stop = make(chan struct{})
+	if err := client.Idle(stop); err != nil {
+		t.Errorf("Idle error: %s", err)
+	}
+	close(stop)
+	<-commands
+	if err := client.Noop(); err != nil {
+		t.Errorf("Noop error: %s", err)
+	}
+	if line := <-commands; line != "a005 NOOP\r\n" {
+		t.Errorf("expect: NOOP right after IDLE, got: %q", line)
+	}
+}
+
+func TestRouteWithoutUpdates(t *testing.T) {
+	client := newPipeClient(func(conn net.Conn) {
+		reader := bufio.NewReader(conn)
+		reader.ReadString('\n')
+		conn.Write([]byte("* 1 FETCH (UID 7)\r\n* 3 EXISTS\r\na001 OK FETCH completed\r\n"))
+	})
+	resp := client.Do("FETCH 1 UID")
+	if resp.Error() != nil {
+		t.Fatalf("FETCH error: %s", resp.Error())
+	}
+	if len(resp.Replys()) != 2 || resp.Replys()[1].Origin() != "3 EXISTS" {
+		t.Errorf("expect: 3 EXISTS kept without Updates, got: %v", resp.Replys())
+	}
+}
+
+func TestBinary(t *testing.T) {
+	commands := make(chan string, 2)
+	client := newPipeClient(func(conn net.Conn) {
+		reader := bufio.NewReader(conn)
+		line, _ := reader.ReadString('\n')
+		commands <- line
+		conn.Write([]byte("* 3 FETCH (BINARY.SIZE[2] 4 BINARY[2] ~{4}\r\n\x00\x01\r\n)\r\na001 OK FETCH completed\r\n"))
+		line, _ = reader.ReadString('\n')
+		body := make([]byte, 3)
+		io.ReadFull(reader, body)
+		rest, _ := reader.ReadString('\n')
+		commands <- line + string(body) + rest
+		conn.Write([]byte("a002 OK APPEND completed\r\n"))
+	})
+	client.caps["LITERAL+"] = true
+	content, err := client.FetchBinary("3", "2")
+	if err != nil {
+		t.Fatalf("FetchBinary error: %s", err)
+	}
+	if line := <-commands; line != "a001 FETCH 3 (BINARY.PEEK[2])\r\n" {
+		t.Errorf("unexpected command: %q", line)
+	}
+	if string(content) != "\x00\x01\r\n" {
+		t.Errorf("expect: \\x00\\x01\\r\\n, got: %q", content)
+	}
+	_, err = client.AppendMulti(Inbox, []*AppendMessage{{Body: bytes.NewBufferString("a\x00b"), Size: 3, Binary: true}})
+	if err != nil {
+		t.Fatalf("AppendMulti error: %s", err)
+	}
+	if line := <-commands; line != "a002 APPEND \"INBOX\" ~{3+}\r\na\x00b\r\n" {
+		t.Errorf("unexpected command: %q", line)
+	}
+}
+
+func TestPreview(t *testing.T) {
+	fields, err := parseFields([]byte(`1 FETCH (UID 7 PREVIEW "Hi there" SAVEDATE " 2-Mar-2021 10:00:00 +0000" EMAILID (M6d99ac3275bb4e) THREADID NIL)`))
+	if err != nil {
+		t.Fatalf("parseFields error: %s", err)
+	}
+	data, err := parseFetch(fields)
+	if err != nil {
+		t.Fatalf("parseFetch error: %s", err)
+	}
+	if data.Preview != "Hi there" {
+		t.Errorf("expect: Hi there, got: %s", data.Preview)
+	}
+	if data.SaveDate.Day() != 2 || data.SaveDate.Hour() != 10 {
+		t.Errorf("expect: 2-Mar-2021 10:00:00, got: %s", data.SaveDate)
+	}
+	if data.EmailID != "M6d99ac3275bb4e" || data.ThreadID != "" {
+		t.Errorf("expect: M6d99ac3275bb4e and no thread, got: %s %s", data.EmailID, data.ThreadID)
+	}
+
+	fields, _ = parseFields([]byte(`STATUS INBOX (MESSAGES 2 MAILBOXID (F2212ea87))`))
+	status, err := parseStatus(fields)
+	if err != nil {
+		t.Fatalf("parseStatus error: %s", err)
+	}
+	if status.MailboxID != "F2212ea87" || status.Messages != 2 {
+		t.Errorf("expect: F2212ea87 with 2 messages, got: %s %d", status.MailboxID, status.Messages)
+	}
+	resp := NewResponse()
+	resp.Feed([]byte("* OK [MAILBOXID (F2212ea87)] Ok\r\na001 OK [READ-WRITE] SELECT completed\r\n"))
+	selected, err := parseSelect(resp)
+	if err != nil {
+		t.Fatalf("parseSelect error: %s", err)
+	}
+	if selected.MailboxID != "F2212ea87" {
+		t.Errorf("expect: F2212ea87, got: %s", selected.MailboxID)
+	}
+
+	commands := make(chan string, 3)
+	client := newPipeClient(func(conn net.Conn) {
+		reader := bufio.NewReader(conn)
+		line, _ := reader.ReadString('\n')
+		commands <- line
+		conn.Write([]byte("* 1 FETCH (PREVIEW NIL)\r\na001 OK FETCH completed\r\n"))
+		line, _ = reader.ReadString('\n')
+		commands <- line
+		conn.Write([]byte("* 1 FETCH (BODYSTRUCTURE (((\"TEXT\" \"HTML\" (\"CHARSET\" \"UTF-8\") NIL NIL \"BASE64\" 48 1) \"ALTERNATIVE\") " +
+			"(\"TEXT\" \"PLAIN\" (\"CHARSET\" \"UTF-8\") NIL NIL \"7BIT\" 4 1 NIL (\"ATTACHMENT\" (\"FILENAME\" \"a.txt\")) NIL) \"MIXED\"))\r\n" +
+			"a002 OK FETCH completed\r\n"))
+		line, _ = reader.ReadString('\n')
+		commands <- line
+		// Cut inside the base64 of "<p>Hello\r\n   <b>wörld</b></p>".
+		part := "PHA+SGVsbG8NCiAgIDxi\r\nPnfDtnJsZDwvYj48L3"
+		conn.Write([]byte(fmt.Sprintf("* 1 FETCH (BODY[1.1]<0> {%d}\r\n%s)\r\na003 OK FETCH completed\r\n", len(part), part)))
+	})
+	client.caps["PREVIEW"] = true
+	preview, err := client.GetPreview("1")
+	if err != nil || preview != "" {
+		t.Errorf("expect: no preview yet, got: %q %v", preview, err)
+	}
+	if line := <-commands; line != "a001 FETCH 1 (PREVIEW (LAZY))\r\n" {
+		t.Errorf("unexpected command: %q", line)
+	}
+	client.caps["PREVIEW"] = false
+	preview, err = client.GetPreview("1")
+	if err != nil {
+		t.Fatalf("GetPreview error: %s", err)
+	}
+	if line := <-commands; line != "a002 FETCH 1 (BODYSTRUCTURE)\r\n" {
+		t.Errorf("unexpected command: %q", line)
+	}
+	if line := <-commands; line != "a003 FETCH 1 (BODY.PEEK[1.1]<0.4096>)\r\n" {
+		t.Errorf("unexpected command: %q", line)
+	}
+	if preview != "Hello wörld" {
+		t.Errorf("expect: Hello wörld, got: %q", preview)
+	}
+
+	conv := convCharset
+	defer func() {
+		convCharset = conv
+	}()
+	convCharset = func(s, to, from string) (string, error) {
+		switch {
+		case from == "ISO-8859-1":
+			runes := make([]rune, len(s))
+			for i := 0; i < len(s); i++ {
+				runes[i] = rune(s[i])
+			}
+			return string(runes), nil
+		case from == "GBK" && s == "\xc4\xe3":
+			return "你", nil
+		}
+		return "", errors.New("invalid character")
+	}
+	if text, err := decodePartial([]byte("caf=E9"), "QUOTED-PRINTABLE", "ISO-8859-1"); err != nil || text != "café" {
+		t.Errorf("expect: café, got: %q %v", text, err)
+	}
+	if text, err := decodePartial([]byte("\xc4\xe3\xba"), "8BIT", "GBK"); err != nil || text != "你" {
+		t.Errorf("expect: 你 without the cut character, got: %q %v", text, err)
+	}
+}
+
+func TestUTF8(t *testing.T) {
+	addrs, err := ParseAddress("张三 <zhang@example.cn>, <a@example.com>,")
+	if err != nil {
+		t.Fatalf("ParseAddress error: %s", err)
+	}
+	if len(addrs) != 2 {
+		t.Fatalf("expect: 2, got: %d", len(addrs))
+	}
+	if addrs[0].Name != "张三" || addrs[0].Address != "zhang@example.cn" {
+		t.Errorf("expect: 张三 <zhang@example.cn>, got: %s <%s>", addrs[0].Name, addrs[0].Address)
+	}
+	if addrs[1].Name != "" || addrs[1].Address != "a@example.com" {
+		t.Errorf("expect: <a@example.com>, got: %s <%s>", addrs[1].Name, addrs[1].Address)
+	}
+
+	commands := make(chan string, 2)
+	client := newPipeClient(func(conn net.Conn) {
+		reader := bufio.NewReader(conn)
+		line, _ := reader.ReadString('\n')
+		commands <- line
+		conn.Write([]byte("* ENABLED UTF8=ACCEPT\r\na001 OK ENABLE completed\r\n"))
+		line, _ = reader.ReadString('\n')
+		body := make([]byte, len("Subject: Grüße\r\n\r\n"))
+		io.ReadFull(reader, body)
+		rest, _ := reader.ReadString('\n')
+		commands <- line + string(body) + rest
+		conn.Write([]byte("a002 OK APPEND completed\r\n"))
+	})
+	client.caps["ENABLE"] = true
+	client.caps["LITERAL+"] = true
+	if !client.needsLiteral("Grüße") {
+		t.Errorf("expect: literal before UTF8=ACCEPT")
+	}
+	if err := client.EnableUTF8(); err != nil {
+		t.Fatalf("EnableUTF8 error: %s", err)
+	}
+	if line := <-commands; line != "a001 ENABLE UTF8=ACCEPT\r\n" {
+		t.Errorf("unexpected command: %q", line)
+	}
+	if client.needsLiteral("Grüße") || !client.needsLiteral("a\r\nb") {
+		t.Errorf("expect: quoted UTF-8 but literal line breaks")
+	}
+	if err := client.Create("Entwürfe\r\na003 DELETE INBOX"); err == nil {
+		t.Errorf("expect: mailbox name with a line break refused")
+	}
+	msg := "Subject: Grüße\r\n\r\n"
+	_, err = client.AppendMulti("Entwürfe", []*AppendMessage{{Body: bytes.NewBufferString(msg), Size: int64(len(msg)), UTF8: true}})
+	if err != nil {
+		t.Fatalf("AppendMulti error: %s", err)
+	}
+	if line := <-commands; line != fmt.Sprintf("a002 APPEND \"Entwürfe\" UTF8 (~{%d+}\r\n%s)\r\n", len(msg), msg) {
+		t.Errorf("unexpected command: %q", line)
+	}
+}
+
+func TestParseMIME(t *testing.T) {
+	raw := "Subject: test\r\n" +
+		"Content-Type: multipart/mixed; boundary=outer\r\n" +
+		"\r\n" +
+		"--outer\r\n" +
+		"Content-Type: multipart/alternative; boundary=inner\r\n" +
+		"\r\n" +
+		"--inner\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" +
+		"hello\r\n" +
+		"--inner\r\n" +
+		"Content-Type: text/html; charset=UTF-8\r\n" +
+		"\r\n" +
+		"<p>hello</p>\r\n" +
+		"--inner--\r\n" +
+		"--outer\r\n" +
+		"Content-Type: application/octet-stream\r\n" +
+		"Content-Disposition: attachment; filename=\"a.bin\"\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		"AAEC\r\n" +
+		"--outer--\r\n"
+	msg, err := mail.ReadMessage(bytes.NewBufferString(raw))
+	if err != nil {
+		t.Fatalf("ReadMessage error: %s", err)
+	}
+	root, err := Parse(msg)
+	if err != nil {
+		t.Fatalf("Parse error: %s", err)
+	}
+	if root.MediaType != "multipart/mixed" || len(root.Parts) != 2 {
+		t.Fatalf("expect: multipart/mixed with 2 parts, got: %s with %d", root.MediaType, len(root.Parts))
+	}
+	alternative := root.Parts[0]
+	if len(alternative.Parts) != 2 || alternative.Parts[1].Section != "1.2" || alternative.Parts[1].MediaType != "text/html" {
+		t.Errorf("expect: text/html at 1.2, got: %v", alternative.Parts)
+	}
+	attachment := root.Parts[1]
+	if attachment.Section != "2" || attachment.Disposition != "attachment" || attachment.Filename() != "a.bin" {
+		t.Errorf("expect: attachment a.bin at 2, got: %s %s %s", attachment.Section, attachment.Disposition, attachment.Filename())
+	}
+	content, _ := ioutil.ReadAll(attachment.Body)
+	if string(content) != "\x00\x01\x02" {
+		t.Errorf("expect: \\x00\\x01\\x02, got: %q", content)
+	}
+
+	msg, _ = mail.ReadMessage(bytes.NewBufferString(raw))
+	body, mediatype, charset, err := GetBody(msg, "text/html")
+	if err != nil {
+		t.Fatalf("GetBody error: %s", err)
+	}
+	if body != "<p>hello</p>" || mediatype != "text/html" || charset != "UTF-8" {
+		t.Errorf("expect: <p>hello</p> text/html UTF-8, got: %q %s %s", body, mediatype, charset)
+	}
+
+	msg, _ = mail.ReadMessage(bytes.NewBufferString(raw))
+	body, mediatype, _, err = GetBody(msg, "text/plain")
+	if err != nil || body != "hello" || mediatype != "text/plain" {
+		t.Errorf("expect: hello text/plain, got: %q %s %v", body, mediatype, err)
+	}
+	msg, _ = mail.ReadMessage(bytes.NewBufferString(raw))
+	if _, _, _, err := GetBody(msg, "image/png"); err == nil {
+		t.Errorf("expect: no image/png part")
+	}
+	msg, _ = mail.ReadMessage(bytes.NewBufferString("Subject: hi\r\n\r\nplain\r\n"))
+	single, err := Parse(msg)
+	if err != nil || single.Section != "1" || single.MediaType != "text/plain" {
+		t.Errorf("expect: text/plain at 1, got: %v %v", single, err)
+	}
+
+	sections := make([]string, 0)
+	msg, _ = mail.ReadMessage(bytes.NewBufferString(raw))
+	err = Walk(msg, func(part *Part) error {
+		sections = append(sections, part.Section)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk error: %s", err)
+	}
+	if fmt.Sprint(sections) != "[ 1 1.1 1.2 2]" {
+		t.Errorf("expect: [ 1 1.1 1.2 2], got: %v", sections)
+	}
+}
+
+// fakeServer answers every command of conn with OK, logging them to commands.
+// SELECT reports uidValidity, and NOOP drops the connection when drop is set.
+func fakeServer(conn net.Conn, uidValidity int, drop bool, commands chan<- string) {
+	conn.Write([]byte("* OK ready\r\n"))
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		commands <- line
+		words := strings.Fields(line)
+		switch words[1] {
+		case "SELECT", "EXAMINE":
+			// A zero UIDVALIDITY stands for a mailbox deleted meanwhile.
+			if uidValidity == 0 {
+				fmt.Fprintf(conn, "%s NO Mailbox doesn't exist\r\n", words[0])
+				continue
+			}
+			fmt.Fprintf(conn, "* OK [UIDVALIDITY %d] UIDs valid\r\n", uidValidity)
+		case "NOOP":
+			if drop {
+				conn.Close()
+				return
+			}
+		}
+		fmt.Fprintf(conn, "%s OK done\r\n", words[0])
+	}
+}
+
+// newFakeClient makes a client of a fakeServer, reading its greeting.
+func newFakeClient(conn net.Conn) (*IMAPClient, error) {
+	reader := bufio.NewReader(conn)
+	if _, err := reader.ReadString('\n'); err != nil {
+		return nil, err
+	}
+	return &IMAPClient{
+		conn:    conn,
+		r:       reader,
+		w:       conn,
+		buf:     make([]byte, 1024),
+		caps:    make(map[string]bool),
+		enabled: make(map[string]bool),
+	}, nil
+}
+
+func TestReconnect(t *testing.T) {
+	commands := make(chan string, 20)
+	dials, fails := 0, 0
+	changed, failed := "", ""
+	r := &ReconnectClient{
+		config: ReconnectConfig{
+			Dial: func() (net.Conn, error) {
+				if fails > 0 {
+					fails--
+					return nil, &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+				}
+				dials++
+				uidValidity := dials
+				if dials == 3 {
+					uidValidity = 0
+				}
+				client, server := net.Pipe()
+				go fakeServer(server, uidValidity, dials == 1, commands)
+				return client, nil
+			},
+			Login: func(c *IMAPClient) error {
+				return c.Login("user", "pass")
+			},
+			MinBackoff: 2 * time.Millisecond,
+			MaxBackoff: 3 * time.Millisecond,
+			MaxRetries: 3,
+			UIDValidityChanged: func(mailbox string, old, new uint32) {
+				changed = fmt.Sprintf("%s %d %d", mailbox, old, new)
+			},
+			RestoreFailed: func(cmd string, err error) {
+				failed = fmt.Sprintf("%s: %s", cmd, err)
+			},
+		},
+		newClient: func(conn net.Conn, hostname string) (*IMAPClient, error) {
+			return newFakeClient(conn)
+		},
+	}
+	sleeps := make([]time.Duration, 0)
+	r.sleep = func(d time.Duration) {
+		sleeps = append(sleeps, d)
+	}
+	client, err := r.connect(nil)
+	if err != nil {
+		t.Fatalf("connect error: %s", err)
+	}
+	r.client = client
+	err = r.Do(func(c *IMAPClient) error {
+		_, err := c.SelectMailbox(Inbox, &SelectOptions{CondStore: true})
+		return err
+	})
+	if err != nil {
+		t.Fatalf("SelectMailbox error: %s", err)
+	}
+
+	fails = 3
+	noop := func(c *IMAPClient) error {
+		return c.Noop()
+	}
+	if err := r.Do(noop); err == nil {
+		t.Fatalf("expect: reconnect failing after 3 retries")
+	}
+	if fmt.Sprint(sleeps) != "[2ms 3ms]" {
+		t.Errorf("expect: [2ms 3ms], got: %v", sleeps)
+	}
+	if err := r.Do(noop); err != nil {
+		t.Fatalf("Do error: %s", err)
+	}
+	expects := []string{
+		"a001 LOGIN user pass\r\n",
+		"a002 SELECT \"INBOX\" (CONDSTORE)\r\n",
+		"a003 NOOP\r\n",
+		"a001 LOGIN user pass\r\n",
+		"a002 SELECT \"INBOX\" (CONDSTORE)\r\n",
+		"a003 NOOP\r\n",
+	}
+	for _, expect := range expects {
+		select {
+		case line := <-commands:
+			if line != expect {
+				t.Errorf("expect: %q, got: %q", expect, line)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expect: %q, got nothing", expect)
+		}
+	}
+	if changed != "INBOX 1 2" {
+		t.Errorf("expect: INBOX 1 2, got: %q", changed)
+	}
+
+	// The mailbox is gone on the next reconnect: the client is kept
+	// without it, instead of failing the SELECT on every command.
+	r.client.broken = true
+	if err := r.Do(noop); err != nil {
+		t.Fatalf("Do error: %s", err)
+	}
+	if err := r.Do(noop); err != nil {
+		t.Fatalf("Do error: %s", err)
+	}
+	expects = []string{
+		"a001 LOGIN user pass\r\n",
+		"a002 SELECT \"INBOX\" (CONDSTORE)\r\n",
+		"a003 NOOP\r\n",
+		"a004 NOOP\r\n",
+	}
+	for _, expect := range expects {
+		select {
+		case line := <-commands:
+			if line != expect {
+				t.Errorf("expect: %q, got: %q", expect, line)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expect: %q, got nothing", expect)
+		}
+	}
+	if failed != "SELECT INBOX: NO Mailbox doesn't exist" {
+		t.Errorf("expect: SELECT INBOX failure, got: %q", failed)
+	}
+	if dials != 3 || r.client.selected != nil {
+		t.Errorf("expect: 3 dials and no mailbox selected, got: %d %v", dials, r.client.selected)
+	}
+}
+
+func TestPool(t *testing.T) {
+	commands := make(chan string, 100)
+	created := make(chan *IMAPClient, 10)
+	drop := make(chan bool, 10)
+	connect := func(account string) (*IMAPClient, error) {
+		dropping := false
+		select {
+		case dropping = <-drop:
+		default:
+		}
+		client, server := net.Pipe()
+		go fakeServer(server, 1, dropping, commands)
+		c, err := newFakeClient(client)
+		if err == nil {
+			created <- c
+		}
+		return c, err
+	}
+	get := func(p *Pool) <-chan *IMAPClient {
+		ret := make(chan *IMAPClient, 1)
+		go func() {
+			c, err := p.Get("user")
+			if err != nil {
+				t.Errorf("Get error: %s", err)
+			}
+			ret <- c
+		}()
+		return ret
+	}
+	waitCommand := func(expect string) {
+		for {
+			select {
+			case line := <-commands:
+				if strings.HasSuffix(line, expect) {
+					return
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("expect: %q, got nothing", expect)
+			}
+		}
+	}
+
+	p := NewPool(PoolConfig{Connect: connect, MaxPerAccount: 1, HealthCheck: time.Hour})
+	c1 := <-get(p)
+	<-created
+	waiting := get(p)
+	select {
+	case <-waiting:
+		t.Fatalf("expect: Get waiting beyond MaxPerAccount")
+	case <-time.After(50 * time.Millisecond):
+	}
+	p.Put("user", c1)
+	if c := <-waiting; c != c1 {
+		t.Errorf("expect: the client put back, got another one")
+	}
+	waiting = get(p)
+	p.Discard("user", c1)
+	c2 := <-waiting
+	if c := <-created; c != c2 || c2 == c1 {
+		t.Errorf("expect: a new client after Discard")
+	}
+
+	closed := make(chan error, 1)
+	go func() {
+		closed <- p.Close()
+	}()
+	select {
+	case <-closed:
+		t.Fatalf("expect: Close waiting for the client in use")
+	case <-time.After(50 * time.Millisecond):
+	}
+	p.Put("user", c2)
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatalf("Close didn't return after Put")
+	}
+	waitCommand("LOGOUT\r\n")
+	if _, err := p.Get("user"); err == nil {
+		t.Errorf("expect: Get failing on a closed pool")
+	}
+
+	p = NewPool(PoolConfig{Connect: connect})
+	drop <- true
+	c1 = <-get(p)
+	<-created
+	p.Put("user", c1)
+	c2 = <-get(p)
+	if c := <-created; c != c2 || c2 == c1 {
+		t.Errorf("expect: the dead client dropped by the health check")
+	}
+	p.Put("user", c2)
+	p.Close()
+	waitCommand("LOGOUT\r\n")
+
+	p = NewPool(PoolConfig{Connect: connect, IdleTimeout: time.Nanosecond})
+	c1 = <-get(p)
+	<-created
+	p.Put("user", c1)
+	waitCommand("LOGOUT\r\n")
+	p.mu.Lock()
+	if len(p.accounts) != 0 {
+		t.Errorf("expect: evicted account removed, got: %v", p.accounts)
+	}
+	p.mu.Unlock()
+	p.Close()
+}
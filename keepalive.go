@@ -0,0 +1,193 @@
+package imap
+
+import (
+	"strings"
+	"time"
+)
+
+// updatesBuffer is how many unsolicited replies Updates holds before dropping
+// new ones.
+const updatesBuffer = 100
+
+// Noop does nothing but lets the server send pending updates, like new
+// messages or flag changes. They are sent to Updates.
+func (c *IMAPClient) Noop() error {
+	resp := c.Do("NOOP")
+	c.deliver(resp)
+	return resp.Error()
+}
+
+// Check asks the server to checkpoint the selected mailbox. Pending updates
+// are sent to Updates like with Noop.
+func (c *IMAPClient) Check() error {
+	resp := c.Do("CHECK")
+	c.deliver(resp)
+	return resp.Error()
+}
+
+// Updates returns the channel receiving the unsolicited replies the server
+// sends, like "3 EXISTS" or "5 FETCH (FLAGS (\Seen))". Once it's called, the
+// ones a command didn't ask for are sent here instead of being in its Replys.
+// Replies that don't fit its buffer are dropped, so it should be read without
+// delay.
+func (c *IMAPClient) Updates() <-chan reply {
+	return c.updates()
+}
+
+// updates returns the channel of Updates, creating it on first use.
+func (c *IMAPClient) updates() chan reply {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	if c.updateChan == nil {
+		c.updateChan = make(chan reply, updatesBuffer)
+	}
+	return c.updateChan
+}
+
+// touch records that the connection was just used.
+func (c *IMAPClient) touch() {
+	c.stateMu.Lock()
+	c.lastUsed = time.Now()
+	c.stateMu.Unlock()
+}
+
+// updateReplies are the untagged replies the server may send at any time, to
+// report changes. commandReplies lists the ones that answer each command; the
+// others are sent to Updates instead. NOOP and CHECK answer all of them.
+var (
+	updateReplies = map[string]bool{
+		"EXISTS": true, "RECENT": true, "EXPUNGE": true, "FETCH": true, "VANISHED": true,
+		"FLAGS": true, "STATUS": true, "LIST": true, "METADATA": true,
+	}
+	commandReplies = map[string][]string{
+		"SELECT":      {"FLAGS", "EXISTS", "RECENT", "FETCH", "VANISHED"},
+		"EXAMINE":     {"FLAGS", "EXISTS", "RECENT", "FETCH", "VANISHED"},
+		"FETCH":       {"FETCH", "VANISHED"},
+		"STORE":       {"FETCH"},
+		"EXPUNGE":     {"EXPUNGE", "VANISHED"},
+		"MOVE":        {"EXPUNGE", "VANISHED"},
+		"LIST":        {"LIST", "STATUS"},
+		"STATUS":      {"STATUS"},
+		"GETMETADATA": {"METADATA"},
+	}
+)
+
+// route takes the updates out of the replies of cmd and sends them to
+// Updates, so that they aren't read as the command's answer. Without Updates
+// they are left in the replies.
+func (c *IMAPClient) route(cmd string, resp *Response) {
+	c.stateMu.Lock()
+	updates := c.updateChan
+	c.stateMu.Unlock()
+	if updates == nil {
+		return
+	}
+	words := strings.Fields(cmd)
+	if len(words) > 1 && strings.ToUpper(words[0]) == "UID" {
+		words = words[1:]
+	}
+	if len(words) == 0 {
+		return
+	}
+	name := strings.ToUpper(words[0])
+	if name == "NOOP" || name == "CHECK" {
+		return
+	}
+	expected := make(map[string]bool)
+	for _, kind := range commandReplies[name] {
+		expected[kind] = true
+	}
+	kept := resp.replys[:0]
+	for _, r := range resp.replys {
+		kind := replyKind(r)
+		if !updateReplies[kind] || expected[kind] {
+			kept = append(kept, r)
+			continue
+		}
+		sendUpdate(updates, r)
+	}
+	resp.replys = kept
+}
+
+// replyKind returns the name of an untagged reply, like EXISTS for
+// "3 EXISTS" or STATUS for "STATUS INBOX (MESSAGES 3)".
+func replyKind(r reply) string {
+	words := strings.Fields(r.Origin())
+	if len(words) == 0 {
+		return ""
+	}
+	if _, ok := fieldNumber(words[0]); ok && len(words) > 1 {
+		return strings.ToUpper(words[1])
+	}
+	return strings.ToUpper(words[0])
+}
+
+func (c *IMAPClient) deliver(resp *Response) {
+	c.stateMu.Lock()
+	updates := c.updateChan
+	c.stateMu.Unlock()
+	if updates == nil {
+		return
+	}
+	for _, reply := range resp.Replys() {
+		sendUpdate(updates, reply)
+	}
+}
+
+func sendUpdate(updates chan<- reply, r reply) {
+	select {
+	case updates <- r:
+	default:
+	}
+}
+
+// StartKeepalive sends NOOP whenever the connection has been idle for
+// interval, so that NAT gateways and the server's autologout timer don't drop
+// it. It stops on StopKeepalive, Close, or the first failed NOOP. An interval
+// of 0 or less only stops the running keepalive.
+func (c *IMAPClient) StartKeepalive(interval time.Duration) {
+	c.StopKeepalive()
+	if interval <= 0 {
+		return
+	}
+	stop := make(chan struct{})
+	c.stateMu.Lock()
+	c.keepaliveStop = stop
+	c.keepalive = interval
+	c.stateMu.Unlock()
+	go c.keepaliveLoop(interval, stop)
+}
+
+// StopKeepalive stops the loop started by StartKeepalive.
+func (c *IMAPClient) StopKeepalive() {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	if c.keepaliveStop != nil {
+		close(c.keepaliveStop)
+		c.keepaliveStop = nil
+		c.keepalive = 0
+	}
+}
+
+func (c *IMAPClient) keepaliveLoop(interval time.Duration, stop chan struct{}) {
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-timer.C:
+		}
+		c.stateMu.Lock()
+		idle := time.Since(c.lastUsed)
+		c.stateMu.Unlock()
+		if idle < interval {
+			timer.Reset(interval - idle)
+			continue
+		}
+		if err := c.Noop(); err != nil {
+			return
+		}
+		timer.Reset(interval)
+	}
+}
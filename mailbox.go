@@ -0,0 +1,256 @@
+package imap
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Mailbox attributes returned by LIST and LSUB.
+const (
+	NoInferiors   = "\\Noinferiors"
+	NoSelect      = "\\Noselect"
+	Marked        = "\\Marked"
+	Unmarked      = "\\Unmarked"
+	HasChildren   = "\\HasChildren"
+	HasNoChildren = "\\HasNoChildren"
+	NonExistent   = "\\NonExistent"
+	Subscribed    = "\\Subscribed"
+	Remote        = "\\Remote"
+)
+
+// Special-use mailbox attributes (RFC 6154).
+const (
+	UseAll     = "\\All"
+	UseArchive = "\\Archive"
+	UseDrafts  = "\\Drafts"
+	UseFlagged = "\\Flagged"
+	UseJunk    = "\\Junk"
+	UseSent    = "\\Sent"
+	UseTrash   = "\\Trash"
+)
+
+// Mailbox is a mailbox as returned by LIST or LSUB. Delimiter is empty if the
+// server has no hierarchy.
+type Mailbox struct {
+	Name       string
+	Delimiter  string
+	Attributes []string
+
+	// ChildInfo and OldName come from LIST-EXTENDED data, Status from
+	// LIST-STATUS. They are only set when the server sent them.
+	ChildInfo []string
+	OldName   string
+	Status    *MailboxStatus
+}
+
+// HasAttribute reports whether the mailbox has attr. Attributes compare
+// case-insensitively.
+func (m *Mailbox) HasAttribute(attr string) bool {
+	for _, i := range m.Attributes {
+		if strings.EqualFold(i, attr) {
+			return true
+		}
+	}
+	return false
+}
+
+// List returns the mailboxes matching pattern, relative to reference. In the
+// pattern "*" matches any name and "%" doesn't match the hierarchy delimiter.
+func (c *IMAPClient) List(reference, pattern string) ([]*Mailbox, error) {
+	return c.list("LIST", fmt.Sprintf("%s %s", c.mailboxName(reference), c.mailboxName(pattern)))
+}
+
+// Lsub is like List, but only returns the subscribed mailboxes.
+func (c *IMAPClient) Lsub(reference, pattern string) ([]*Mailbox, error) {
+	return c.list("LSUB", fmt.Sprintf("%s %s", c.mailboxName(reference), c.mailboxName(pattern)))
+}
+
+// ListOptions are the options of LIST-EXTENDED (RFC 5258).
+type ListOptions struct {
+	// Select holds selection options, like SUBSCRIBED, REMOTE, RECURSIVEMATCH
+	// or SPECIAL-USE.
+	Select []string
+	// Return holds return options, like SUBSCRIBED, CHILDREN or SPECIAL-USE.
+	Return []string
+	// Status asks for these STATUS items of every mailbox (LIST-STATUS),
+	// returned in Mailbox.Status.
+	Status []string
+}
+
+// ListExtended is List with several patterns and LIST-EXTENDED options. opts
+// may be nil.
+func (c *IMAPClient) ListExtended(reference string, patterns []string, opts *ListOptions) ([]*Mailbox, error) {
+	if opts == nil {
+		opts = &ListOptions{}
+	}
+	args := ""
+	if len(opts.Select) > 0 {
+		args += fmt.Sprintf("(%s) ", strings.Join(opts.Select, " "))
+	}
+	names := make([]string, len(patterns))
+	for i, p := range patterns {
+		names[i] = c.mailboxName(p)
+	}
+	args += fmt.Sprintf("%s (%s)", c.mailboxName(reference), strings.Join(names, " "))
+	returns := append([]string{}, opts.Return...)
+	if len(opts.Status) > 0 {
+		returns = append(returns, fmt.Sprintf("STATUS (%s)", strings.Join(opts.Status, " ")))
+	}
+	if len(returns) > 0 {
+		args += fmt.Sprintf(" RETURN (%s)", strings.Join(returns, " "))
+	}
+	return c.list("LIST", args)
+}
+
+// FindSpecialUse returns the mailbox marked with use, like UseSent or
+// UseTrash, or nil if there is none. It falls back to a plain LIST when the
+// server doesn't take the SPECIAL-USE selection option.
+func (c *IMAPClient) FindSpecialUse(use string) (*Mailbox, error) {
+	boxes, err := c.ListExtended("", []string{"*"}, &ListOptions{Select: []string{"SPECIAL-USE"}})
+	if err != nil {
+		boxes, err = c.List("", "*")
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, box := range boxes {
+		if box.HasAttribute(use) {
+			return box, nil
+		}
+	}
+	return nil, nil
+}
+
+func (c *IMAPClient) list(cmd, args string) ([]*Mailbox, error) {
+	resp := c.Do(fmt.Sprintf("%s %s", cmd, args))
+	if resp.Error() != nil {
+		return nil, resp.Error()
+	}
+	boxes, err := parseList(resp, cmd)
+	if err != nil {
+		return nil, err
+	}
+	for _, box := range boxes {
+		box.Name = c.decodeMailbox(box.Name)
+		if box.OldName != "" {
+			box.OldName = c.decodeMailbox(box.OldName)
+		}
+		if box.Status != nil {
+			box.Status.Name = box.Name
+		}
+	}
+	return boxes, nil
+}
+
+func parseList(resp *Response, cmd string) ([]*Mailbox, error) {
+	ret := make([]*Mailbox, 0)
+	statuses := make(map[string]*MailboxStatus)
+	for _, reply := range resp.Replys() {
+		fields, err := reply.Fields()
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		switch name, _ := fields[0].(string); strings.ToUpper(name) {
+		case cmd:
+			mailbox, err := parseMailbox(fields[1:])
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, mailbox)
+		case "STATUS":
+			status, err := parseStatus(fields)
+			if err != nil {
+				return nil, err
+			}
+			statuses[status.Name] = status
+		}
+	}
+	for _, box := range ret {
+		if status, ok := statuses[box.Name]; ok {
+			box.Status = status
+		}
+	}
+	return ret, nil
+}
+
+func parseMailbox(fields []interface{}) (*Mailbox, error) {
+	if len(fields) < 3 {
+		return nil, errors.New("Invalid LIST response")
+	}
+	attrs, ok := fields[0].([]interface{})
+	if !ok {
+		return nil, errors.New("Invalid mailbox attributes")
+	}
+	ret := &Mailbox{
+		Attributes: make([]string, 0, len(attrs)),
+	}
+	for _, i := range attrs {
+		attr, ok := i.(string)
+		if !ok {
+			return nil, errors.New("Invalid mailbox attributes")
+		}
+		ret.Attributes = append(ret.Attributes, attr)
+	}
+	if ret.Delimiter, ok = fieldString(fields[1]); !ok {
+		return nil, errors.New("Invalid mailbox delimiter")
+	}
+	if ret.Name, ok = fields[2].(string); !ok {
+		return nil, errors.New("Invalid mailbox name")
+	}
+	if len(fields) < 4 {
+		return ret, nil
+	}
+	extended, _ := fields[3].([]interface{})
+	for i := 0; i+1 < len(extended); i += 2 {
+		tag, _ := extended[i].(string)
+		values, _ := extended[i+1].([]interface{})
+		switch strings.ToUpper(tag) {
+		case "CHILDINFO":
+			for _, v := range values {
+				if s, ok := v.(string); ok {
+					ret.ChildInfo = append(ret.ChildInfo, s)
+				}
+			}
+		case "OLDNAME":
+			if len(values) > 0 {
+				ret.OldName, _ = values[0].(string)
+			}
+		}
+	}
+	return ret, nil
+}
+
+// Create creates the mailbox name.
+func (c *IMAPClient) Create(name string) error {
+	return c.Do(fmt.Sprintf("CREATE %s", c.mailboxName(name))).Error()
+}
+
+// CreateSpecialUse creates the mailbox name marked with the given special-use
+// attributes, like UseSent (CREATE-SPECIAL-USE).
+func (c *IMAPClient) CreateSpecialUse(name string, uses ...string) error {
+	return c.Do(fmt.Sprintf("CREATE %s (USE (%s))", c.mailboxName(name), strings.Join(uses, " "))).Error()
+}
+
+// Delete deletes the mailbox name.
+func (c *IMAPClient) Delete(name string) error {
+	return c.Do(fmt.Sprintf("DELETE %s", c.mailboxName(name))).Error()
+}
+
+// Rename renames the mailbox from to the name to.
+func (c *IMAPClient) Rename(from, to string) error {
+	return c.Do(fmt.Sprintf("RENAME %s %s", c.mailboxName(from), c.mailboxName(to))).Error()
+}
+
+// Subscribe adds name to the subscribed mailboxes returned by Lsub.
+func (c *IMAPClient) Subscribe(name string) error {
+	return c.Do(fmt.Sprintf("SUBSCRIBE %s", c.mailboxName(name))).Error()
+}
+
+// Unsubscribe removes name from the subscribed mailboxes.
+func (c *IMAPClient) Unsubscribe(name string) error {
+	return c.Do(fmt.Sprintf("UNSUBSCRIBE %s", c.mailboxName(name))).Error()
+}
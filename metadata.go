@@ -0,0 +1,133 @@
+package imap
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// MetadataOptions limit what GetMetadata returns (RFC 5464).
+type MetadataOptions struct {
+	// MaxSize leaves out values larger than this many octets. 0 means no
+	// limit.
+	MaxSize uint32
+	// Depth also returns entries below the given ones: "1" for the children,
+	// "infinity" for all descendants. Empty means none.
+	Depth string
+}
+
+// GetMetadata returns the values of entries, like "/private/comment", of
+// mailbox, or of the server if mailbox is "". Entries without value are
+// missing from the returned map. opts may be nil. When values were left out
+// because of MaxSize, the size of the largest one is returned too.
+func (c *IMAPClient) GetMetadata(mailbox string, entries []string, opts *MetadataOptions) (map[string]string, uint32, error) {
+	args := make([]string, 0)
+	if opts != nil && opts.MaxSize > 0 {
+		args = append(args, fmt.Sprintf("MAXSIZE %d", opts.MaxSize))
+	}
+	if opts != nil && opts.Depth != "" {
+		args = append(args, "DEPTH "+opts.Depth)
+	}
+	cmd := "GETMETADATA "
+	if len(args) > 0 {
+		cmd += "(" + strings.Join(args, " ") + ") "
+	}
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = quoteString(entry)
+	}
+	resp := c.Do(fmt.Sprintf("%s%s (%s)", cmd, c.mailboxName(mailbox), strings.Join(names, " ")))
+	if resp.Error() != nil {
+		return nil, 0, resp.Error()
+	}
+	return parseMetadata(resp)
+}
+
+// SetMetadata sets the given entries of mailbox, or of the server if mailbox
+// is "". An empty value removes the entry. Values with line breaks or
+// non-ASCII characters are sent as literals.
+func (c *IMAPClient) SetMetadata(mailbox string, entries map[string]string) error {
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := []interface{}{fmt.Sprintf("SETMETADATA %s (", c.mailboxName(mailbox))}
+	for i, name := range names {
+		sep := " "
+		if i == 0 {
+			sep = ""
+		}
+		value := entries[name]
+		switch {
+		case value == "":
+			parts = append(parts, fmt.Sprintf("%s%s NIL", sep, quoteString(name)))
+		case c.needsLiteral(value):
+			parts = append(parts, fmt.Sprintf("%s%s ", sep, quoteString(name)), &literal{
+				r:    strings.NewReader(value),
+				size: int64(len(value)),
+			})
+		default:
+			parts = append(parts, fmt.Sprintf("%s%s %s", sep, quoteString(name), quoteString(value)))
+		}
+	}
+	parts = append(parts, ")")
+	return c.do(parts...).Error()
+}
+
+// needsLiteral reports whether str can't be sent as a quoted string.
+func needsLiteral(str string) bool {
+	for i := 0; i < len(str); i++ {
+		if str[i] < 0x20 || str[i] >= 0x7f {
+			return true
+		}
+	}
+	return false
+}
+
+func parseMetadata(resp *Response) (map[string]string, uint32, error) {
+	ret := make(map[string]string)
+	for _, reply := range resp.Replys() {
+		fields, err := reply.Fields()
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(fields) < 3 {
+			continue
+		}
+		if name, _ := fields[0].(string); strings.ToUpper(name) != "METADATA" {
+			continue
+		}
+		// Unsolicited METADATA responses only list the changed entries.
+		list, ok := fields[2].([]interface{})
+		if !ok {
+			continue
+		}
+		if len(list)%2 != 0 {
+			return nil, 0, errors.New("Invalid METADATA response")
+		}
+		for i := 0; i < len(list); i += 2 {
+			entry, ok := list[i].(string)
+			if !ok {
+				return nil, 0, errors.New("Invalid METADATA response")
+			}
+			if list[i+1] == nil {
+				continue
+			}
+			value, ok := list[i+1].(string)
+			if !ok {
+				return nil, 0, errors.New("Invalid METADATA response")
+			}
+			ret[entry] = value
+		}
+	}
+	var longest uint32
+	if code, args := responseCode(resp.Status()); code == "METADATA" && len(args) == 2 {
+		if name, _ := args[0].(string); strings.ToUpper(name) == "LONGENTRIES" {
+			n, _ := fieldNumber(args[1])
+			longest = uint32(n)
+		}
+	}
+	return ret, longest, nil
+}
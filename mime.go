@@ -0,0 +1,160 @@
+package imap
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strconv"
+	"strings"
+
+	"github.com/googollee/go-encoding-ex"
+)
+
+// Part is a node of the MIME tree of a message. A part without Content-Type
+// is text/plain, as in RFC 2045.
+type Part struct {
+	Header            mail.Header
+	MediaType         string
+	Params            map[string]string
+	Disposition       string
+	DispositionParams map[string]string
+
+	// Section is the IMAP section number of the part, like "1.2", as used by
+	// FetchBinary. It's empty for a multipart message itself, and "1" for the
+	// body of a message that isn't multipart.
+	Section string
+
+	// Body reads the content decoded from its Content-Transfer-Encoding. It's
+	// nil for multiparts, whose children are in Parts.
+	Body  io.Reader
+	Parts []*Part
+}
+
+// IsMultipart reports whether p holds other parts.
+func (p *Part) IsMultipart() bool {
+	return strings.HasPrefix(p.MediaType, "multipart/")
+}
+
+// Filename returns the file name of an attachment, if it has one.
+func (p *Part) Filename() string {
+	if name := p.DispositionParams["filename"]; name != "" {
+		return name
+	}
+	return p.Params["name"]
+}
+
+// WalkFunc is called by Walk for each part. Returning an error stops the walk,
+// and Walk returns it.
+type WalkFunc func(part *Part) error
+
+// Walk calls fn for msg and each of its parts, depth first and in order, while
+// reading msg. A multipart is visited before its children, with Parts still
+// empty. Body is only valid until fn returns; what isn't read of it is
+// skipped.
+func Walk(msg *mail.Message, fn WalkFunc) error {
+	return walk(msg.Header, msg.Body, "", fn)
+}
+
+func walk(header mail.Header, body io.Reader, section string, fn WalkFunc) error {
+	part := newPart(header, section)
+	if !part.IsMultipart() {
+		if part.Section == "" {
+			part.Section = "1"
+		}
+		part.Body = decodeBody(header, body)
+		return fn(part)
+	}
+	if err := fn(part); err != nil {
+		return err
+	}
+	boundary := part.Params["boundary"]
+	if boundary == "" {
+		return errors.New("multipart without boundary")
+	}
+	reader := multipart.NewReader(body, boundary)
+	for i := 1; ; i++ {
+		p, err := reader.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		child := strconv.Itoa(i)
+		if section != "" {
+			child = section + "." + child
+		}
+		if err := walk(mail.Header(p.Header), p, child, fn); err != nil {
+			return err
+		}
+	}
+}
+
+// Parse reads the whole MIME tree of msg. Bodies are kept in memory; use Walk
+// to stream them instead.
+func Parse(msg *mail.Message) (*Part, error) {
+	var root *Part
+	parts := make(map[string]*Part)
+	err := Walk(msg, func(part *Part) error {
+		if part.Body != nil {
+			body, err := ioutil.ReadAll(part.Body)
+			if err != nil {
+				return err
+			}
+			part.Body = bytes.NewReader(body)
+		}
+		if root == nil {
+			root = part
+		} else {
+			parent := parts[parentSection(part.Section)]
+			parent.Parts = append(parent.Parts, part)
+		}
+		parts[part.Section] = part
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+// parentSection returns the section of the multipart holding section.
+func parentSection(section string) string {
+	if i := strings.LastIndex(section, "."); i >= 0 {
+		return section[:i]
+	}
+	return ""
+}
+
+func newPart(header mail.Header, section string) *Part {
+	ret := &Part{
+		Header:    header,
+		MediaType: "text/plain",
+		Params:    make(map[string]string),
+		Section:   section,
+	}
+	if mediatype, params, err := mime.ParseMediaType(header.Get("Content-Type")); err == nil {
+		ret.MediaType, ret.Params = mediatype, params
+	}
+	ret.DispositionParams = make(map[string]string)
+	if disposition, params, err := mime.ParseMediaType(header.Get("Content-Disposition")); err == nil {
+		ret.Disposition, ret.DispositionParams = disposition, params
+	}
+	return ret
+}
+
+// decodeBody undoes the Content-Transfer-Encoding of body.
+func decodeBody(header mail.Header, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(header.Get("Content-Transfer-Encoding"))) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, encodingex.NewIgnoreReader(body, []byte("\r\n")))
+	case "quoted-printable":
+		return encodingex.NewQuotedPrintableDecoder(body)
+	}
+	return body
+}
@@ -0,0 +1,92 @@
+package imap
+
+import (
+	"errors"
+	"strings"
+)
+
+// Namespace is a prefix of mailbox names, with the hierarchy delimiter used
+// under it.
+type Namespace struct {
+	Prefix    string
+	Delimiter string
+}
+
+// Namespaces is the result of NAMESPACE (RFC 2342). Each list is empty if the
+// server has no such namespace.
+type Namespaces struct {
+	Personal   []Namespace
+	OtherUsers []Namespace
+	Shared     []Namespace
+}
+
+// Namespace returns the prefixes of the personal, other users' and shared
+// mailboxes.
+func (c *IMAPClient) Namespace() (*Namespaces, error) {
+	resp := c.Do("NAMESPACE")
+	if resp.Error() != nil {
+		return nil, resp.Error()
+	}
+	ret, err := parseNamespace(resp)
+	if err != nil {
+		return nil, err
+	}
+	for _, list := range [][]Namespace{ret.Personal, ret.OtherUsers, ret.Shared} {
+		for i := range list {
+			list[i].Prefix = c.decodeMailbox(list[i].Prefix)
+		}
+	}
+	return ret, nil
+}
+
+func parseNamespace(resp *Response) (*Namespaces, error) {
+	for _, reply := range resp.Replys() {
+		fields, err := reply.Fields()
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		if name, _ := fields[0].(string); strings.ToUpper(name) != "NAMESPACE" {
+			continue
+		}
+		if len(fields) < 4 {
+			return nil, errors.New("Invalid NAMESPACE response")
+		}
+		ret := &Namespaces{}
+		for i, list := range []*[]Namespace{&ret.Personal, &ret.OtherUsers, &ret.Shared} {
+			if *list, err = parseNamespaceList(fields[i+1]); err != nil {
+				return nil, err
+			}
+		}
+		return ret, nil
+	}
+	return nil, errors.New("Invalid response")
+}
+
+// parseNamespaceList reads NIL or a list like (("" "/") ("#shared." ".")).
+// Namespace extensions after the delimiter are skipped.
+func parseNamespaceList(field interface{}) ([]Namespace, error) {
+	ret := make([]Namespace, 0)
+	if field == nil {
+		return ret, nil
+	}
+	list, ok := field.([]interface{})
+	if !ok {
+		return nil, errors.New("Invalid NAMESPACE response")
+	}
+	for _, i := range list {
+		desc, ok := i.([]interface{})
+		if !ok || len(desc) < 2 {
+			return nil, errors.New("Invalid NAMESPACE response")
+		}
+		prefix, isPrefix := desc[0].(string)
+		delimiter, isDelimiter := fieldString(desc[1])
+		if !isPrefix || !isDelimiter {
+			return nil, errors.New("Invalid NAMESPACE response")
+		}
+		ret = append(ret, Namespace{prefix, delimiter})
+	}
+	return ret, nil
+}
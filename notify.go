@@ -0,0 +1,75 @@
+package imap
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Mailbox specifiers of NOTIFY (RFC 5465).
+const (
+	NotifySelected        = "SELECTED"
+	NotifySelectedDelayed = "SELECTED-DELAYED"
+	NotifyInboxes         = "INBOXES"
+	NotifyPersonal        = "PERSONAL"
+	NotifySubscribed      = "SUBSCRIBED"
+	NotifySubtree         = "SUBTREE"
+	NotifyMailboxes       = "MAILBOXES"
+)
+
+// Events of NOTIFY.
+const (
+	EventMessageNew            = "MessageNew"
+	EventMessageExpunge        = "MessageExpunge"
+	EventFlagChange            = "FlagChange"
+	EventAnnotationChange      = "AnnotationChange"
+	EventMailboxName           = "MailboxName"
+	EventSubscriptionChange    = "SubscriptionChange"
+	EventMailboxMetadataChange = "MailboxMetadataChange"
+	EventServerMetadataChange  = "ServerMetadataChange"
+)
+
+// NotifyGroup is a set of mailboxes and the events to report about them.
+type NotifyGroup struct {
+	// Mailboxes is one of the Notify* specifiers. NotifySubtree and
+	// NotifyMailboxes take the mailbox names in Names.
+	Mailboxes string
+	Names     []string
+	// Events are the Event* names. For the selected mailbox MessageNew may
+	// ask for fetch items, like "MessageNew (UID FLAGS)". No events means
+	// none are reported for these mailboxes.
+	Events []string
+}
+
+// Notify asks the server to report the events of groups (NOTIFY SET). With
+// status, it first sends a STATUS for every mailbox of the groups. Events are
+// sent to Updates, whichever command is running when they arrive.
+func (c *IMAPClient) Notify(status bool, groups ...NotifyGroup) error {
+	args := make([]string, 0, len(groups))
+	for _, g := range groups {
+		spec := g.Mailboxes
+		if len(g.Names) > 0 {
+			names := make([]string, len(g.Names))
+			for i, name := range g.Names {
+				names[i] = c.mailboxName(name)
+			}
+			spec += " (" + strings.Join(names, " ") + ")"
+		}
+		events := "NONE"
+		if len(g.Events) > 0 {
+			events = "(" + strings.Join(g.Events, " ") + ")"
+		}
+		args = append(args, fmt.Sprintf("(%s %s)", spec, events))
+	}
+	cmd := "NOTIFY SET"
+	if status {
+		cmd += " STATUS"
+	}
+	resp := c.Do(fmt.Sprintf("%s %s", cmd, strings.Join(args, " ")))
+	c.deliver(resp)
+	return resp.Error()
+}
+
+// NotifyNone stops all NOTIFY events.
+func (c *IMAPClient) NotifyNone() error {
+	return c.Do("NOTIFY NONE").Error()
+}
@@ -0,0 +1,217 @@
+package imap
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// PoolConfig configures a Pool.
+type PoolConfig struct {
+	// Connect returns a new authenticated client for account.
+	Connect func(account string) (*IMAPClient, error)
+	// MaxPerAccount caps the connections open to one account, as servers limit
+	// concurrent sessions. Get waits when it's reached. 0 means no limit.
+	MaxPerAccount int
+	// IdleTimeout logs out connections left idle for this long. 0 keeps them
+	// until Close.
+	IdleTimeout time.Duration
+	// HealthCheck makes Get send NOOP to connections idle for longer than this
+	// before handing them out, dropping the dead ones. 0 checks every time.
+	HealthCheck time.Duration
+}
+
+// Pool keeps authenticated connections of many accounts for reuse. A client
+// from Get must be given back with Put, or with Discard if it's broken.
+// Clients are handed out in the state they were put back in, like with a
+// mailbox selected.
+type Pool struct {
+	config   PoolConfig
+	mu       sync.Mutex
+	cond     *sync.Cond
+	accounts map[string]*poolAccount
+	closed   bool
+	stop     chan struct{}
+}
+
+type poolAccount struct {
+	idle []*pooledClient
+	open int
+}
+
+type pooledClient struct {
+	client   *IMAPClient
+	lastUsed time.Time
+}
+
+// NewPool makes an empty pool.
+func NewPool(config PoolConfig) *Pool {
+	ret := &Pool{
+		config:   config,
+		accounts: make(map[string]*poolAccount),
+		stop:     make(chan struct{}),
+	}
+	ret.cond = sync.NewCond(&ret.mu)
+	if config.IdleTimeout > 0 {
+		go ret.evict()
+	}
+	return ret
+}
+
+func (p *Pool) account(name string) *poolAccount {
+	ret, ok := p.accounts[name]
+	if !ok {
+		ret = &poolAccount{}
+		p.accounts[name] = ret
+	}
+	return ret
+}
+
+// Get returns an idle connection of account, or a new one if there's none.
+func (p *Pool) Get(account string) (*IMAPClient, error) {
+	p.mu.Lock()
+	for {
+		if p.closed {
+			p.mu.Unlock()
+			return nil, errors.New("pool is closed")
+		}
+		a := p.account(account)
+		if n := len(a.idle); n > 0 {
+			pc := a.idle[n-1]
+			a.idle = a.idle[:n-1]
+			p.mu.Unlock()
+			if time.Since(pc.lastUsed) <= p.config.HealthCheck || pc.client.Noop() == nil {
+				return pc.client, nil
+			}
+			pc.client.Close()
+			p.mu.Lock()
+			a.open--
+			p.cond.Broadcast()
+			continue
+		}
+		if p.config.MaxPerAccount <= 0 || a.open < p.config.MaxPerAccount {
+			a.open++
+			p.mu.Unlock()
+			c, err := p.config.Connect(account)
+			if err != nil {
+				p.mu.Lock()
+				a.open--
+				p.cond.Broadcast()
+				p.mu.Unlock()
+				return nil, err
+			}
+			return c, nil
+		}
+		p.cond.Wait()
+	}
+}
+
+// Put gives c, which Get returned for account, back to the pool.
+func (p *Pool) Put(account string, c *IMAPClient) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	a := p.account(account)
+	if p.closed {
+		a.open--
+		p.cond.Broadcast()
+		go logout(c)
+		return
+	}
+	a.idle = append(a.idle, &pooledClient{
+		client:   c,
+		lastUsed: time.Now(),
+	})
+	p.cond.Broadcast()
+}
+
+// Discard closes c, which Get returned for account, instead of reusing it.
+func (p *Pool) Discard(account string, c *IMAPClient) {
+	c.Close()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.account(account).open--
+	p.cond.Broadcast()
+}
+
+// Close logs out the idle connections, and waits for the ones in use to be
+// given back to log them out too.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	close(p.stop)
+	idle := make([]*pooledClient, 0)
+	for _, a := range p.accounts {
+		idle = append(idle, a.idle...)
+		a.open -= len(a.idle)
+		a.idle = nil
+	}
+	p.cond.Broadcast()
+	p.mu.Unlock()
+
+	for _, pc := range idle {
+		logout(pc.client)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for !p.drained() {
+		p.cond.Wait()
+	}
+	return nil
+}
+
+func (p *Pool) drained() bool {
+	for _, a := range p.accounts {
+		if a.open > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *Pool) evict() {
+	interval := p.config.IdleTimeout / 2
+	if interval <= 0 {
+		interval = p.config.IdleTimeout
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+		expired := make([]*pooledClient, 0)
+		p.mu.Lock()
+		for name, a := range p.accounts {
+			kept := a.idle[:0]
+			for _, pc := range a.idle {
+				if time.Since(pc.lastUsed) > p.config.IdleTimeout {
+					expired = append(expired, pc)
+					a.open--
+				} else {
+					kept = append(kept, pc)
+				}
+			}
+			a.idle = kept
+			if a.open == 0 {
+				delete(p.accounts, name)
+			}
+		}
+		p.cond.Broadcast()
+		p.mu.Unlock()
+		for _, pc := range expired {
+			logout(pc.client)
+		}
+	}
+}
+
+func logout(c *IMAPClient) {
+	c.Logout()
+	c.Close()
+}
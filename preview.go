@@ -0,0 +1,201 @@
+package imap
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/mail"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/googollee/go-encoding-ex"
+)
+
+// previewLength is the most characters of a preview, as in RFC 8970.
+const previewLength = 256
+
+// previewFetchSize is how much of a text part GetPreview fetches to build a
+// preview without PREVIEW.
+const previewFetchSize = 4096
+
+// GetPreview returns a short plain text snippet of the message id. With
+// PREVIEW it's the server's, which may be empty when the server hasn't
+// computed it yet (LAZY). Otherwise it's made from the start of the text part
+// of the message, found with BODYSTRUCTURE and fetched without marking the
+// message \Seen.
+func (c *IMAPClient) GetPreview(id string) (string, error) {
+	if c.HasCapability("PREVIEW") {
+		datas, err := c.FetchItems(id, "PREVIEW (LAZY)")
+		if err != nil {
+			return "", err
+		}
+		if len(datas) == 0 {
+			return "", errors.New("Invalid response")
+		}
+		return datas[0].Preview, nil
+	}
+
+	datas, err := c.FetchItems(id, "BODYSTRUCTURE")
+	if err != nil {
+		return "", err
+	}
+	if len(datas) == 0 {
+		return "", errors.New("Invalid response")
+	}
+	part, html := textPart(datas[0].bodyStructure, "")
+	if part == nil {
+		part = html
+	}
+	if part == nil {
+		return "", nil
+	}
+	datas, err = c.FetchItems(id, fmt.Sprintf("BODY.PEEK[%s]<0.%d>", part.section, previewFetchSize))
+	if err != nil {
+		return "", err
+	}
+	if len(datas) == 0 || datas[0].Sections == nil {
+		return "", errors.New("Invalid response")
+	}
+	body, err := decodePartial(datas[0].Sections[part.section], part.encoding, part.charset)
+	if err != nil {
+		return "", err
+	}
+	if part == html {
+		body = stripTags(body)
+	}
+	return makePreview(body), nil
+}
+
+// previewPart is a text part found in a BODYSTRUCTURE.
+type previewPart struct {
+	section  string
+	encoding string
+	charset  string
+}
+
+// textPart returns the first text/plain and text/html parts of the
+// BODYSTRUCTURE bs, which is at section, skipping attachments.
+func textPart(bs interface{}, section string) (plain, html *previewPart) {
+	list, _ := bs.([]interface{})
+	if len(list) == 0 {
+		return nil, nil
+	}
+	if _, ok := list[0].([]interface{}); ok {
+		for i, child := range list {
+			if _, ok := child.([]interface{}); !ok {
+				break
+			}
+			sub := fmt.Sprint(i + 1)
+			if section != "" {
+				sub = section + "." + sub
+			}
+			p, h := textPart(child, sub)
+			if plain == nil {
+				plain = p
+			}
+			if html == nil {
+				html = h
+			}
+			if plain != nil {
+				return
+			}
+		}
+		return
+	}
+	if len(list) < 7 {
+		return nil, nil
+	}
+	mediatype, _ := list[0].(string)
+	subtype, _ := list[1].(string)
+	if !strings.EqualFold(mediatype, "TEXT") {
+		return nil, nil
+	}
+	// Text parts have MD5 and the disposition after their line count.
+	if len(list) > 9 {
+		if disposition, ok := list[9].([]interface{}); ok && len(disposition) > 0 {
+			if name, _ := disposition[0].(string); strings.EqualFold(name, "ATTACHMENT") {
+				return nil, nil
+			}
+		}
+	}
+	if section == "" {
+		section = "1"
+	}
+	ret := &previewPart{section: section}
+	ret.encoding, _ = list[5].(string)
+	if params, ok := list[2].([]interface{}); ok {
+		for i := 0; i+1 < len(params); i += 2 {
+			if name, _ := params[i].(string); strings.EqualFold(name, "CHARSET") {
+				ret.charset, _ = params[i+1].(string)
+			}
+		}
+	}
+	switch strings.ToUpper(subtype) {
+	case "PLAIN":
+		return ret, nil
+	case "HTML":
+		return nil, ret
+	}
+	return nil, nil
+}
+
+// convCharset converts text between charsets. It's a variable for tests.
+var convCharset = encodingex.Conv
+
+// decodePartial decodes the start of a part in charset to UTF-8. The part may
+// be cut in the middle of its Content-Transfer-Encoding or of a character.
+func decodePartial(content []byte, encoding, charset string) (string, error) {
+	if strings.EqualFold(encoding, "BASE64") {
+		content = bytes.Replace(bytes.Replace(content, []byte("\r"), nil, -1), []byte("\n"), nil, -1)
+		content = content[:len(content)-len(content)%4]
+	}
+	header := mail.Header{"Content-Transfer-Encoding": {encoding}}
+	body, _ := ioutil.ReadAll(decodeBody(header, bytes.NewReader(content)))
+	if charset != "" && !strings.EqualFold(charset, "UTF-8") && !strings.EqualFold(charset, "US-ASCII") {
+		// Only a cut character at the end may fail the conversion, so
+		// it's retried without the last bytes.
+		for i := 0; ; i++ {
+			text, err := convCharset(string(body), "UTF-8", charset)
+			if err == nil || i == utf8.UTFMax-1 || len(body) == 0 {
+				return text, err
+			}
+			body = body[:len(body)-1]
+		}
+	}
+	for i := 0; i < utf8.UTFMax && len(body) > 0; i++ {
+		if r, size := utf8.DecodeLastRune(body); r != utf8.RuneError || size != 1 {
+			break
+		}
+		body = body[:len(body)-1]
+	}
+	return string(body), nil
+}
+
+// stripTags drops the markup of an html body, roughly.
+func stripTags(html string) string {
+	var buf bytes.Buffer
+	inTag := false
+	for _, r := range html {
+		switch {
+		case r == '<':
+			inTag = true
+		case r == '>' && inTag:
+			inTag = false
+			buf.WriteByte(' ')
+		case !inTag:
+			buf.WriteRune(r)
+		}
+	}
+	return buf.String()
+}
+
+// makePreview collapses the white space of text and cuts it to previewLength
+// characters.
+func makePreview(text string) string {
+	ret := []rune(strings.Join(strings.Fields(text), " "))
+	if len(ret) > previewLength {
+		ret = ret[:previewLength]
+	}
+	return string(ret)
+}
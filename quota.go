@@ -0,0 +1,149 @@
+package imap
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Quota resources of RFC 9208. STORAGE counts units of 1024 octets.
+const (
+	QuotaStorage = "STORAGE"
+	QuotaMessage = "MESSAGE"
+	QuotaMailbox = "MAILBOX"
+)
+
+// QuotaResource is the usage and limit of one resource of a quota root.
+type QuotaResource struct {
+	Name  string
+	Usage uint64
+	Limit uint64
+}
+
+// Quota is a quota root with the resources it limits.
+type Quota struct {
+	Root      string
+	Resources []QuotaResource
+}
+
+// Resource returns the resource called name, like QuotaStorage, or nil if the
+// root doesn't limit it.
+func (q *Quota) Resource(name string) *QuotaResource {
+	for i := range q.Resources {
+		if strings.EqualFold(q.Resources[i].Name, name) {
+			return &q.Resources[i]
+		}
+	}
+	return nil
+}
+
+// GetQuota returns the usage and limits of the quota root.
+func (c *IMAPClient) GetQuota(root string) (*Quota, error) {
+	resp := c.Do(fmt.Sprintf("GETQUOTA %s", quoteString(root)))
+	if resp.Error() != nil {
+		return nil, resp.Error()
+	}
+	_, quotas, err := parseQuota(resp)
+	if err != nil {
+		return nil, err
+	}
+	if len(quotas) == 0 {
+		return nil, errors.New("Invalid response")
+	}
+	return quotas[0], nil
+}
+
+// GetQuotaRoot returns the quota roots mailbox is under, with their usage and
+// limits.
+func (c *IMAPClient) GetQuotaRoot(mailbox string) ([]*Quota, error) {
+	resp := c.Do(fmt.Sprintf("GETQUOTAROOT %s", c.mailboxName(mailbox)))
+	if resp.Error() != nil {
+		return nil, resp.Error()
+	}
+	roots, quotas, err := parseQuota(resp)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]*Quota, len(roots))
+	for i, root := range roots {
+		ret[i] = &Quota{Root: root}
+		for _, q := range quotas {
+			if q.Root == root {
+				ret[i] = q
+			}
+		}
+	}
+	return ret, nil
+}
+
+// SetQuota sets the limits of the quota root, like {QuotaStorage: 512}.
+// Resources not in limits become unlimited.
+func (c *IMAPClient) SetQuota(root string, limits map[string]uint64) error {
+	names := make([]string, 0, len(limits))
+	for name := range limits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	strs := make([]string, len(names))
+	for i, name := range names {
+		strs[i] = fmt.Sprintf("%s %d", name, limits[name])
+	}
+	return c.Do(fmt.Sprintf("SETQUOTA %s (%s)", quoteString(root), strings.Join(strs, " "))).Error()
+}
+
+// IsOverQuota reports whether err is an [OVERQUOTA] status, which APPEND,
+// COPY and MOVE answer when the destination would exceed its quota.
+func IsOverQuota(err error) bool {
+	e, ok := err.(*ResponseError)
+	return ok && e.Code == "OVERQUOTA"
+}
+
+// parseQuota reads the QUOTAROOT and QUOTA responses of resp.
+func parseQuota(resp *Response) ([]string, []*Quota, error) {
+	roots := make([]string, 0)
+	quotas := make([]*Quota, 0)
+	for _, reply := range resp.Replys() {
+		fields, err := reply.Fields()
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		switch name, _ := fields[0].(string); strings.ToUpper(name) {
+		case "QUOTAROOT":
+			if len(fields) < 2 {
+				return nil, nil, errors.New("Invalid QUOTAROOT response")
+			}
+			for _, f := range fields[2:] {
+				root, ok := f.(string)
+				if !ok {
+					return nil, nil, errors.New("Invalid QUOTAROOT response")
+				}
+				roots = append(roots, root)
+			}
+		case "QUOTA":
+			if len(fields) < 3 {
+				return nil, nil, errors.New("Invalid QUOTA response")
+			}
+			root, ok := fields[1].(string)
+			list, isList := fields[2].([]interface{})
+			if !ok || !isList || len(list)%3 != 0 {
+				return nil, nil, errors.New("Invalid QUOTA response")
+			}
+			q := &Quota{Root: root}
+			for i := 0; i < len(list); i += 3 {
+				name, ok := list[i].(string)
+				usage, isUsage := fieldNumber(list[i+1])
+				limit, isLimit := fieldNumber(list[i+2])
+				if !ok || !isUsage || !isLimit {
+					return nil, nil, errors.New("Invalid QUOTA response")
+				}
+				q.Resources = append(q.Resources, QuotaResource{strings.ToUpper(name), usage, limit})
+			}
+			quotas = append(quotas, q)
+		}
+	}
+	return roots, quotas, nil
+}
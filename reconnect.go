@@ -0,0 +1,231 @@
+package imap
+
+import (
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ReconnectConfig configures a ReconnectClient.
+type ReconnectConfig struct {
+	// Dial opens a new connection to the server, and Hostname is the name its
+	// TLS certificate is checked against.
+	Dial     func() (net.Conn, error)
+	Hostname string
+	// Login authenticates a new client. It's called on every connect, so the
+	// credentials are only kept by the caller.
+	Login func(c *IMAPClient) error
+	// MinBackoff and MaxBackoff bound the wait between failed connects, which
+	// doubles each time. They default to 1 second and 1 minute.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// MaxRetries is how many connects are tried before giving up, 0 means
+	// trying forever.
+	MaxRetries int
+	// UIDValidityChanged is called when the mailbox selected again after a
+	// reconnect has another UIDVALIDITY, so that cached UIDs are invalid.
+	UIDValidityChanged func(mailbox string, old, new uint32)
+	// RestoreFailed is called when the server refuses a command restoring
+	// the session after a reconnect, like the SELECT of a mailbox deleted
+	// meanwhile. The new client is kept, without that part of the session.
+	RestoreFailed func(cmd string, err error)
+}
+
+// ReconnectClient keeps an authenticated IMAPClient. When the connection
+// dies it dials again, logs in, turns compression and the extensions that were
+// enabled back on, and selects the mailbox that was selected.
+type ReconnectClient struct {
+	config ReconnectConfig
+	mu     sync.Mutex
+	client *IMAPClient
+	// state is the session of the last client, kept until a reconnect
+	// succeeds.
+	state *session
+
+	newClient func(conn net.Conn, hostname string) (*IMAPClient, error)
+	sleep     func(d time.Duration)
+}
+
+// NewReconnectClient connects to the server and logs in.
+func NewReconnectClient(config ReconnectConfig) (*ReconnectClient, error) {
+	if config.MinBackoff <= 0 {
+		config.MinBackoff = time.Second
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = time.Minute
+	}
+	ret := &ReconnectClient{
+		config:    config,
+		newClient: NewClient,
+		sleep:     time.Sleep,
+	}
+	client, err := ret.connect(nil)
+	if err != nil {
+		return nil, err
+	}
+	ret.client = client
+	return ret, nil
+}
+
+// Do runs f with the current client. If f fails because the connection is
+// broken, Do reconnects and runs f once more, so f should be safe to repeat.
+// Errors the server answered, like NO, are returned without reconnecting.
+func (r *ReconnectClient) Do(f func(c *IMAPClient) error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.client == nil {
+		if err := r.reconnect(); err != nil {
+			return err
+		}
+	}
+	err := f(r.client)
+	if !isConnError(err) {
+		return err
+	}
+	if err := r.reconnect(); err != nil {
+		return err
+	}
+	return f(r.client)
+}
+
+// Close logs out and closes the connection.
+func (r *ReconnectClient) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.client == nil {
+		return nil
+	}
+	r.client.Logout()
+	err := r.client.Close()
+	r.client = nil
+	return err
+}
+
+// session is the state of a client which is restored after reconnecting.
+type session struct {
+	enabled    []string
+	condStore  bool
+	selected   *selection
+	keepalive  time.Duration
+	compressed bool
+}
+
+func (c *IMAPClient) session() *session {
+	c.stateMu.Lock()
+	keepalive := c.keepalive
+	c.stateMu.Unlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ret := &session{
+		selected:   c.selected,
+		keepalive:  keepalive,
+		compressed: c.deflate != nil,
+		condStore:  c.condStoreBySelect,
+	}
+	for cap := range c.enabled {
+		if cap == "CONDSTORE" && c.condStoreBySelect {
+			continue
+		}
+		ret.enabled = append(ret.enabled, cap)
+	}
+	return ret
+}
+
+func (r *ReconnectClient) reconnect() error {
+	if r.client != nil {
+		r.state = r.client.session()
+		r.client.Close()
+		r.client = nil
+	}
+	backoff := r.config.MinBackoff
+	for retry := 1; ; retry++ {
+		client, err := r.connect(r.state)
+		if err == nil {
+			r.client = client
+			r.state = nil
+			return nil
+		}
+		if !isConnError(err) || (r.config.MaxRetries > 0 && retry >= r.config.MaxRetries) {
+			return err
+		}
+		r.sleep(backoff)
+		if backoff *= 2; backoff > r.config.MaxBackoff {
+			backoff = r.config.MaxBackoff
+		}
+	}
+}
+
+// connect makes a new client and restores state, if any.
+func (r *ReconnectClient) connect(state *session) (*IMAPClient, error) {
+	conn, err := r.config.Dial()
+	if err != nil {
+		return nil, err
+	}
+	c, err := r.newClient(conn, r.config.Hostname)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := r.config.Login(c); err != nil {
+		c.Close()
+		return nil, err
+	}
+	if state == nil {
+		return c, nil
+	}
+
+	// A refused command only loses its part of the session: restoring it
+	// again would fail the same way on every reconnect.
+	restored := func(cmd string, err error) bool {
+		if err == nil {
+			return true
+		}
+		if isConnError(err) {
+			c.Close()
+			return false
+		}
+		if r.config.RestoreFailed != nil {
+			r.config.RestoreFailed(cmd, err)
+		}
+		return true
+	}
+	if state.compressed {
+		if err := c.Compress(); !restored("COMPRESS DEFLATE", err) {
+			return nil, err
+		}
+	}
+	if len(state.enabled) > 0 {
+		if _, err := c.Enable(state.enabled...); !restored("ENABLE "+strings.Join(state.enabled, " "), err) {
+			return nil, err
+		}
+	}
+	if s := state.selected; s != nil {
+		cmd := "SELECT "
+		if s.readOnly {
+			cmd = "EXAMINE "
+		}
+		data, err := c.SelectMailbox(s.mailbox, &SelectOptions{ReadOnly: s.readOnly, CondStore: state.condStore})
+		if !restored(cmd+s.mailbox, err) {
+			return nil, err
+		}
+		if err == nil && data.UIDValidity != s.uidValidity && r.config.UIDValidityChanged != nil {
+			r.config.UIDValidityChanged(s.mailbox, s.uidValidity, data.UIDValidity)
+		}
+	}
+	if state.keepalive > 0 {
+		c.StartKeepalive(state.keepalive)
+	}
+	return c, nil
+}
+
+// isConnError reports whether err comes from a broken connection, instead of
+// being a status the server answered.
+func isConnError(err error) bool {
+	if err == io.EOF || err == io.ErrUnexpectedEOF || err == errBroken {
+		return true
+	}
+	_, ok := err.(net.Error)
+	return ok
+}
@@ -1,6 +1,7 @@
 package imap
 
 import (
+	"bytes"
 	"errors"
 	"strconv"
 	"strings"
@@ -38,6 +39,180 @@ func (r reply) Content() string {
 	return string(r.content)
 }
 
+// Fields parses the reply into its data items. Atoms, quoted strings and
+// literals become string, NIL becomes nil, and parenthesized lists become
+// []interface{}. Bracketed parts such as BODY[HEADER] or [UIDVALIDITY 3] are
+// kept in their atom. The text of an OK, NO, BAD, BYE or PREAUTH reply is free
+// form, so it's kept whole as the second field.
+func (r reply) Fields() ([]interface{}, error) {
+	switch replyKind(r) {
+	case "OK", "NO", "BAD", "BYE", "PREAUTH":
+		words := strings.SplitN(r.Origin(), " ", 2)
+		ret := []interface{}{words[0]}
+		if len(words) > 1 {
+			ret = append(ret, words[1])
+		}
+		return ret, nil
+	}
+	return parseFields(r.origin)
+}
+
+type fieldParser struct {
+	buf []byte
+	pos int
+}
+
+func parseFields(b []byte) ([]interface{}, error) {
+	p := &fieldParser{buf: b}
+	return p.parseList(0)
+}
+
+func (p *fieldParser) parseList(end byte) ([]interface{}, error) {
+	ret := make([]interface{}, 0)
+	for {
+		for p.pos < len(p.buf) && p.buf[p.pos] == byte(' ') {
+			p.pos++
+		}
+		if p.pos >= len(p.buf) {
+			if end != 0 {
+				return nil, errors.New("Parse response error, unclosed list")
+			}
+			return ret, nil
+		}
+		c := p.buf[p.pos]
+		switch {
+		case end != 0 && c == end:
+			p.pos++
+			return ret, nil
+		case c == byte(')'):
+			return nil, errors.New("Parse response error, unexpected )")
+		case c == byte('('):
+			p.pos++
+			list, err := p.parseList(byte(')'))
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, list)
+		case c == byte('"'):
+			str, err := p.parseQuoted()
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, str)
+		case c == byte('{') || (c == byte('~') && p.pos+1 < len(p.buf) && p.buf[p.pos+1] == byte('{')):
+			str, err := p.parseLiteral()
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, str)
+		default:
+			atom := p.parseAtom()
+			if strings.ToUpper(atom) == "NIL" {
+				ret = append(ret, nil)
+			} else {
+				ret = append(ret, atom)
+			}
+		}
+	}
+}
+
+func (p *fieldParser) parseQuoted() (string, error) {
+	ret := make([]byte, 0)
+	for p.pos++; p.pos < len(p.buf); p.pos++ {
+		switch c := p.buf[p.pos]; c {
+		case byte('\\'):
+			p.pos++
+			if p.pos < len(p.buf) {
+				ret = append(ret, p.buf[p.pos])
+			}
+		case byte('"'):
+			p.pos++
+			return string(ret), nil
+		default:
+			ret = append(ret, c)
+		}
+	}
+	return "", errors.New("Parse response error, unclosed quoted string")
+}
+
+func (p *fieldParser) parseLiteral() (string, error) {
+	if p.buf[p.pos] == byte('~') {
+		p.pos++
+	}
+	end := bytes.Index(p.buf[p.pos:], []byte("}\r\n"))
+	if end < 0 {
+		return "", errors.New("Parse response error, invalid literal")
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(string(p.buf[p.pos+1:p.pos+end]), "+"))
+	if err != nil {
+		return "", errors.New("Parse response error, invalid literal length")
+	}
+	p.pos += end + 3
+	if p.pos+n > len(p.buf) {
+		return "", errors.New("Parse response error, literal too short")
+	}
+	ret := string(p.buf[p.pos : p.pos+n])
+	p.pos += n
+	return ret, nil
+}
+
+func (p *fieldParser) parseAtom() string {
+	start := p.pos
+	depth := 0
+	for ; p.pos < len(p.buf); p.pos++ {
+		c := p.buf[p.pos]
+		switch {
+		case c == byte('['):
+			depth++
+		case c == byte(']') && depth > 0:
+			depth--
+		case depth > 0:
+		case c == byte(' ') || c == byte('(') || c == byte(')'):
+			return string(p.buf[start:p.pos])
+		}
+	}
+	return string(p.buf[start:p.pos])
+}
+
+// responseCode splits the response code off a status text like
+// "OK [UIDNEXT 4392] Predicted next UID". It returns the upper cased code name
+// and its arguments, or an empty name if the text has no code.
+func responseCode(text string) (string, []interface{}) {
+	i := strings.Index(text, "[")
+	if i < 0 || strings.Contains(strings.TrimSpace(text[:i]), " ") {
+		return "", nil
+	}
+	p := &fieldParser{buf: []byte(text), pos: i}
+	atom := p.parseAtom()
+	if !strings.HasSuffix(atom, "]") {
+		return "", nil
+	}
+	fields, err := parseFields([]byte(atom[1 : len(atom)-1]))
+	if err != nil || len(fields) == 0 {
+		return "", nil
+	}
+	name, _ := fields[0].(string)
+	return strings.ToUpper(name), fields[1:]
+}
+
+// fieldString returns f as a string, treating NIL as the empty string.
+func fieldString(f interface{}) (string, bool) {
+	if f == nil {
+		return "", true
+	}
+	s, ok := f.(string)
+	return s, ok
+}
+
+func fieldNumber(f interface{}) (uint64, bool) {
+	s, ok := f.(string)
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(s, 10, 64)
+	return n, err == nil
+}
+
 type feedStatus int
 
 const (
@@ -45,14 +220,26 @@ const (
 	feedStar
 	feedReply
 	feedReplyType
-	feedReplyLength
 	feedReplyContent
 	feedReplyMeet0d
 	feedStatusLine
 	feedStatusLineMeet0d
+	feedContinuation
 	feedFinished
 )
 
+// ResponseError is the error of a command the server didn't answer with OK.
+type ResponseError struct {
+	// Status is the whole status, like "NO [TRYCREATE] No such mailbox".
+	Status string
+	// Code is the upper cased response code, like "TRYCREATE", if any.
+	Code string
+}
+
+func (e *ResponseError) Error() string {
+	return e.Status
+}
+
 type Response struct {
 	id     string
 	status string
@@ -63,6 +250,14 @@ type Response struct {
 	feedStatus       feedStatus
 	parenthesisCount int
 	reply            reply
+	literal          []byte
+	literalLength    int
+	continued        bool
+	// consumed is how many bytes of its input the last Feed used.
+	consumed int
+	// enabled is a copy of the set of extensions enabled on the connection,
+	// which changes how some replies are read. It's nil if unknown.
+	enabled map[string]bool
 }
 
 func NewResponse() *Response {
@@ -74,11 +269,14 @@ func NewResponse() *Response {
 }
 
 func (r *Response) Feed(input []byte) (bool, error) {
-	for _, i := range input {
+	r.consumed = len(input)
+	for n, i := range input {
 		switch r.feedStatus {
 		case feedInit:
 			if i == byte('*') {
 				r.feedStatus = feedStar
+			} else if i == byte('+') {
+				r.feedStatus = feedContinuation
 			} else {
 				r.feedStatus = feedStatusLine
 				r.buf = append(r.buf, i)
@@ -101,39 +299,41 @@ func (r *Response) Feed(input []byte) (bool, error) {
 			}
 		case feedReplyType:
 			switch i {
+			case byte('\r'):
+				r.feedStatus = feedReplyMeet0d
+				continue
 			case byte(')'):
 				r.feedStatus = feedReply
 			case byte(' '):
 				if len(r.reply.type_) > 0 {
-					r.feedStatus = feedReplyLength
+					r.feedStatus = feedReply
 				}
 			default:
 				r.reply.type_ = append(r.reply.type_, i)
 			}
 			r.reply.origin = append(r.reply.origin, i)
-		case feedReplyLength:
-			r.reply.origin = append(r.reply.origin, i)
-			if i == byte('\n') {
-				r.feedStatus = feedReplyContent
-			}
-			if byte('0') <= i && i <= byte('9') {
-				r.reply.length = append(r.reply.length, i)
-			}
 		case feedReplyContent:
 			r.reply.origin = append(r.reply.origin, i)
-			r.reply.content = append(r.reply.content, i)
-			i, err := r.reply.Length()
-			if err != nil {
-				return false, errors.New("Parse response error, reply need a valid length number")
-			}
-			if len(r.reply.content) == i {
-				r.feedStatus = feedReply
+			r.literal = append(r.literal, i)
+			if len(r.literal) == r.literalLength {
+				r.endLiteral()
 			}
 		case feedReplyMeet0d:
 			if i == byte('\n') {
-				r.feedStatus = feedInit
-				r.replys = append(r.replys, r.reply)
-				r.buf = r.buf[0:0]
+				n, ok := literalLength(r.reply.origin)
+				if !ok {
+					r.feedStatus = feedInit
+					r.endReply()
+					r.buf = r.buf[0:0]
+					continue
+				}
+				r.reply.origin = append(r.reply.origin, byte('\r'), byte('\n'))
+				r.literal = r.literal[0:0]
+				r.literalLength = n
+				r.feedStatus = feedReplyContent
+				if n == 0 {
+					r.endLiteral()
+				}
 			} else {
 				r.feedStatus = feedReply
 				r.reply.origin = append(r.reply.origin, i)
@@ -155,13 +355,25 @@ func (r *Response) Feed(input []byte) (bool, error) {
 					r.status = array[1]
 				}
 				if len(r.status) < 3 || r.status[:3] != "OK " {
-					r.err = errors.New(r.status)
+					code, _ := responseCode(r.status)
+					r.err = &ResponseError{
+						Status: r.status,
+						Code:   code,
+					}
 				}
+				r.consumed = n + 1
 				return true, nil
 			} else {
 				r.feedStatus = feedStatusLine
 				r.buf = append(r.buf, byte('\r'), i)
 			}
+		case feedContinuation:
+			if i == byte('\n') {
+				r.feedStatus = feedInit
+				r.continued = true
+				r.consumed = n + 1
+				return true, nil
+			}
 		case feedFinished:
 			return true, errors.New("Need no more feed")
 		}
@@ -169,6 +381,51 @@ func (r *Response) Feed(input []byte) (bool, error) {
 	return false, nil
 }
 
+// endReply adds the finished reply, and records the extensions an ENABLED
+// reply turns on.
+func (r *Response) endReply() {
+	r.replys = append(r.replys, r.reply)
+	words := strings.Fields(r.reply.Origin())
+	if r.enabled == nil || len(words) == 0 || strings.ToUpper(words[0]) != "ENABLED" {
+		return
+	}
+	for _, cap := range words[1:] {
+		cap = strings.ToUpper(cap)
+		r.enabled[cap] = true
+		if cap == "QRESYNC" {
+			r.enabled["CONDSTORE"] = true
+		}
+	}
+}
+
+// endLiteral finishes reading a literal and goes on with the rest of the
+// reply line. Only the first literal of a reply is kept as its content.
+func (r *Response) endLiteral() {
+	if r.reply.length == nil {
+		r.reply.length = []byte(strconv.Itoa(len(r.literal)))
+		r.reply.content = append(r.reply.content, r.literal...)
+	}
+	r.feedStatus = feedReply
+}
+
+// literalLength checks whether line ends with a literal prefix like {123}
+// or {123+}, and returns the announced length.
+func literalLength(line []byte) (int, bool) {
+	if len(line) < 3 || line[len(line)-1] != byte('}') {
+		return 0, false
+	}
+	start := bytes.LastIndex(line, []byte("{"))
+	if start < 0 {
+		return 0, false
+	}
+	number := strings.TrimSuffix(string(line[start+1:len(line)-1]), "+")
+	n, err := strconv.Atoi(number)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
+
 func (r *Response) Id() string {
 	return r.id
 }
@@ -181,6 +438,12 @@ func (r *Response) Error() error {
 	return r.err
 }
 
+// Enabled reports whether cap was enabled on the connection when the response
+// was read, by an earlier command or by the response itself.
+func (r *Response) Enabled(cap string) bool {
+	return r.enabled[strings.ToUpper(cap)]
+}
+
 func (r *Response) Replys() []reply {
 	return r.replys
 }
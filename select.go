@@ -0,0 +1,268 @@
+package imap
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// QResyncParams is what a client remembers about a mailbox to resynchronize
+// it with QRESYNC (RFC 7162).
+type QResyncParams struct {
+	UIDValidity uint32
+	ModSeq      uint64
+	// KnownUIDs optionally limits the report to these UIDs, like "1:300".
+	KnownUIDs string
+}
+
+// SelectOptions changes how SelectMailbox opens a mailbox.
+type SelectOptions struct {
+	// ReadOnly sends EXAMINE instead of SELECT.
+	ReadOnly  bool
+	CondStore bool
+	// QResync needs QRESYNC to be enabled first, see Enable.
+	QResync *QResyncParams
+}
+
+// SelectData is what the server tells about a mailbox when it's selected.
+type SelectData struct {
+	Flags          FlagSet
+	PermanentFlags FlagSet
+	Exists         uint32
+	Recent         uint32
+	Unseen         uint32
+	UIDValidity    uint32
+	UIDNext        uint32
+	HighestModSeq  uint64
+	NoModSeq       bool
+	MailboxID      string
+	ReadOnly       bool
+
+	// Vanished and Changed are only filled when selecting with QResync. They
+	// hold the UIDs expunged and the messages changed since QResync.ModSeq.
+	Vanished SeqSet
+	Changed  []*FetchData
+}
+
+// SelectMailbox selects box like Select, and returns what the server reported
+// about it. opts may be nil.
+func (c *IMAPClient) SelectMailbox(box string, opts *SelectOptions) (*SelectData, error) {
+	if opts == nil {
+		opts = &SelectOptions{}
+	}
+	cmd := "SELECT"
+	if opts.ReadOnly {
+		cmd = "EXAMINE"
+	}
+	cmd = fmt.Sprintf("%s %s", cmd, c.mailboxName(box))
+	switch {
+	case opts.QResync != nil:
+		if !c.Enabled("QRESYNC") {
+			return nil, errors.New("QRESYNC is not enabled")
+		}
+		q := opts.QResync
+		params := fmt.Sprintf("%d %d", q.UIDValidity, q.ModSeq)
+		if q.KnownUIDs != "" {
+			params += " " + q.KnownUIDs
+		}
+		cmd += fmt.Sprintf(" (QRESYNC (%s))", params)
+	case opts.CondStore:
+		cmd += " (CONDSTORE)"
+	}
+	resp := c.Do(cmd)
+	c.selected = nil
+	if resp.Error() != nil {
+		return nil, resp.Error()
+	}
+	data, err := parseSelect(resp)
+	if err != nil {
+		return nil, err
+	}
+	if opts.CondStore && !c.enabled["CONDSTORE"] {
+		c.enabled["CONDSTORE"] = true
+		c.condStoreBySelect = true
+	}
+	c.selected = &selection{box, opts.ReadOnly, data.UIDValidity}
+	return data, nil
+}
+
+// selection remembers the selected mailbox, so it can be selected again after
+// reconnecting.
+type selection struct {
+	mailbox     string
+	readOnly    bool
+	uidValidity uint32
+}
+
+func parseSelect(resp *Response) (*SelectData, error) {
+	ret := &SelectData{}
+	code, _ := responseCode(resp.Status())
+	ret.ReadOnly = code == "READ-ONLY"
+	for _, reply := range resp.Replys() {
+		origin := reply.Origin()
+		if len(origin) >= 3 && strings.ToUpper(origin[:3]) == "OK " {
+			code, args := responseCode(origin)
+			var n uint64
+			if len(args) > 0 {
+				n, _ = fieldNumber(args[0])
+			}
+			switch code {
+			case "UIDVALIDITY":
+				ret.UIDValidity = uint32(n)
+			case "UIDNEXT":
+				ret.UIDNext = uint32(n)
+			case "UNSEEN":
+				ret.Unseen = uint32(n)
+			case "HIGHESTMODSEQ":
+				ret.HighestModSeq = n
+			case "NOMODSEQ":
+				ret.NoModSeq = true
+			case "MAILBOXID":
+				if len(args) > 0 {
+					id, err := parseObjectID(args[0])
+					if err != nil {
+						return nil, err
+					}
+					ret.MailboxID = id
+				}
+			case "PERMANENTFLAGS":
+				if len(args) > 0 {
+					flags, err := parseFlagSet(args[0])
+					if err != nil {
+						return nil, err
+					}
+					ret.PermanentFlags = flags
+				}
+			}
+			continue
+		}
+		fields, err := reply.Fields()
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) < 2 {
+			continue
+		}
+		name, _ := fields[0].(string)
+		switch strings.ToUpper(name) {
+		case "FLAGS":
+			flags, err := parseFlagSet(fields[1])
+			if err != nil {
+				return nil, err
+			}
+			ret.Flags = flags
+			continue
+		case "VANISHED":
+			if !resp.Enabled("QRESYNC") {
+				continue
+			}
+			uids, err := parseVanished(fields)
+			if err != nil {
+				return nil, err
+			}
+			ret.Vanished = append(ret.Vanished, uids...)
+			continue
+		}
+		n, ok := fieldNumber(fields[0])
+		if !ok {
+			continue
+		}
+		name, _ = fields[1].(string)
+		switch strings.ToUpper(name) {
+		case "EXISTS":
+			ret.Exists = uint32(n)
+		case "RECENT":
+			ret.Recent = uint32(n)
+		case "FETCH":
+			data, err := parseFetch(fields)
+			if err != nil {
+				return nil, err
+			}
+			ret.Changed = append(ret.Changed, data)
+		}
+	}
+	return ret, nil
+}
+
+// Expunged is what the server reported about the messages an expunge
+// removed.
+type Expunged struct {
+	// SeqNums are the sequence numbers of the EXPUNGE responses, in order.
+	// Each one counts without the messages removed before it, so a number
+	// may repeat.
+	SeqNums []uint32
+	// UIDs are the messages reported by VANISHED instead, when QRESYNC is
+	// enabled.
+	UIDs SeqSet
+}
+
+// Expunge removes the messages marked \Deleted from the selected mailbox,
+// and returns what the server reported about them.
+func (c *IMAPClient) Expunge() (*Expunged, error) {
+	resp := c.Do("EXPUNGE")
+	if resp.Error() != nil {
+		return nil, resp.Error()
+	}
+	return expunged(resp)
+}
+
+// UIDExpunge is Expunge limited to the messages in the UID set uid. It needs
+// UIDPLUS.
+func (c *IMAPClient) UIDExpunge(uid string) (*Expunged, error) {
+	resp := c.Do(fmt.Sprintf("UID EXPUNGE %s", uid))
+	if resp.Error() != nil {
+		return nil, resp.Error()
+	}
+	return expunged(resp)
+}
+
+// CloseMailbox closes the selected mailbox, silently removing the messages
+// marked \Deleted. Close closes the connection instead.
+func (c *IMAPClient) CloseMailbox() error {
+	err := c.Do("CLOSE").Error()
+	if err == nil {
+		c.selected = nil
+	}
+	return err
+}
+
+// Unselect closes the selected mailbox without removing any message. It needs
+// UNSELECT (RFC 3691).
+func (c *IMAPClient) Unselect() error {
+	err := c.Do("UNSELECT").Error()
+	if err == nil {
+		c.selected = nil
+	}
+	return err
+}
+
+// expunged collects the EXPUNGE responses of resp, and the VANISHED ones if
+// QRESYNC is enabled.
+func expunged(resp *Response) (*Expunged, error) {
+	ret := &Expunged{
+		SeqNums: make([]uint32, 0),
+		UIDs:    make(SeqSet, 0),
+	}
+	for _, reply := range resp.Replys() {
+		fields, err := reply.Fields()
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) < 2 {
+			continue
+		}
+		if name, _ := fields[0].(string); resp.Enabled("QRESYNC") && strings.ToUpper(name) == "VANISHED" {
+			uids, err := parseVanished(fields)
+			if err != nil {
+				return nil, err
+			}
+			ret.UIDs = append(ret.UIDs, uids...)
+			continue
+		}
+		n, ok := fieldNumber(fields[0])
+		if name, _ := fields[1].(string); ok && strings.ToUpper(name) == "EXPUNGE" {
+			ret.SeqNums = append(ret.SeqNums, uint32(n))
+		}
+	}
+	return ret, nil
+}
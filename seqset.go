@@ -0,0 +1,97 @@
+package imap
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SeqRange is a range of sequence numbers or UIDs, with From <= To.
+type SeqRange struct {
+	From, To uint32
+}
+
+// SeqSet is a set of sequence numbers or UIDs sent by the server, as its
+// ranges in order. It isn't expanded, since a set like "1:4000000000" in
+// VANISHED (EARLIER) is legal.
+type SeqSet []SeqRange
+
+// Len returns how many numbers s holds.
+func (s SeqSet) Len() uint64 {
+	var ret uint64
+	for _, r := range s {
+		ret += uint64(r.To-r.From) + 1
+	}
+	return ret
+}
+
+// Contains reports whether n is in s.
+func (s SeqSet) Contains(n uint32) bool {
+	_, ok := s.index(n)
+	return ok
+}
+
+// String returns s in the IMAP syntax, like "2,4:7,9".
+func (s SeqSet) String() string {
+	strs := make([]string, len(s))
+	for i, r := range s {
+		if r.From == r.To {
+			strs[i] = fmt.Sprint(r.From)
+		} else {
+			strs[i] = fmt.Sprintf("%d:%d", r.From, r.To)
+		}
+	}
+	return strings.Join(strs, ",")
+}
+
+// index returns the position of n in s.
+func (s SeqSet) index(n uint32) (uint64, bool) {
+	var i uint64
+	for _, r := range s {
+		if n >= r.From && n <= r.To {
+			return i + uint64(n-r.From), true
+		}
+		i += uint64(r.To-r.From) + 1
+	}
+	return 0, false
+}
+
+// at returns the number at position i of s.
+func (s SeqSet) at(i uint64) (uint32, bool) {
+	for _, r := range s {
+		size := uint64(r.To-r.From) + 1
+		if i < size {
+			return r.From + uint32(i), true
+		}
+		i -= size
+	}
+	return 0, false
+}
+
+// parseSeqSet reads a sequence set sent by the server, like "2,4:7,9".
+// Servers never send "*" in a set, so it's an error.
+func parseSeqSet(set string) (SeqSet, error) {
+	ret := make(SeqSet, 0)
+	if set == "" {
+		return ret, nil
+	}
+	for _, part := range strings.Split(set, ",") {
+		bounds := strings.SplitN(part, ":", 2)
+		from, err := strconv.ParseUint(bounds[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sequence set %q", set)
+		}
+		to := from
+		if len(bounds) == 2 {
+			to, err = strconv.ParseUint(bounds[1], 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid sequence set %q", set)
+			}
+		}
+		if from > to {
+			from, to = to, from
+		}
+		ret = append(ret, SeqRange{uint32(from), uint32(to)})
+	}
+	return ret, nil
+}
@@ -0,0 +1,127 @@
+package imap
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Data items of STATUS. SIZE and DELETED need the STATUS=SIZE and IMAP4rev2
+// extensions, HIGHESTMODSEQ needs CONDSTORE and MAILBOXID needs OBJECTID.
+const (
+	StatusMessages      = "MESSAGES"
+	StatusRecent        = "RECENT"
+	StatusUIDNext       = "UIDNEXT"
+	StatusUIDValidity   = "UIDVALIDITY"
+	StatusUnseen        = "UNSEEN"
+	StatusDeleted       = "DELETED"
+	StatusSize          = "SIZE"
+	StatusHighestModSeq = "HIGHESTMODSEQ"
+	StatusMailboxID     = "MAILBOXID"
+)
+
+// MailboxStatus holds the counters of a STATUS response. Counters that weren't
+// asked for are left zero.
+type MailboxStatus struct {
+	Name          string
+	Messages      uint32
+	Recent        uint32
+	UIDNext       uint32
+	UIDValidity   uint32
+	Unseen        uint32
+	Deleted       uint32
+	Size          uint64
+	HighestModSeq uint64
+	MailboxID     string
+}
+
+// Status returns the given counters of mailbox, like StatusUnseen, without
+// selecting it.
+func (c *IMAPClient) Status(mailbox string, items ...string) (*MailboxStatus, error) {
+	resp := c.Do(fmt.Sprintf("STATUS %s (%s)", c.mailboxName(mailbox), strings.Join(items, " ")))
+	if resp.Error() != nil {
+		return nil, resp.Error()
+	}
+	var ret *MailboxStatus
+	for _, reply := range resp.Replys() {
+		fields, err := reply.Fields()
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		if name, _ := fields[0].(string); strings.ToUpper(name) != "STATUS" {
+			continue
+		}
+		status, err := parseStatus(fields)
+		if err != nil {
+			return nil, err
+		}
+		status.Name = c.decodeMailbox(status.Name)
+		if ret == nil || sameMailbox(status.Name, mailbox) {
+			ret = status
+		}
+	}
+	if ret == nil {
+		return nil, errors.New("Invalid response")
+	}
+	return ret, nil
+}
+
+// sameMailbox compares mailbox names, where INBOX is case-insensitive.
+func sameMailbox(a, b string) bool {
+	if strings.EqualFold(a, Inbox) {
+		return strings.EqualFold(b, Inbox)
+	}
+	return a == b
+}
+
+// parseStatus reads "STATUS mailbox (item value ...)".
+func parseStatus(fields []interface{}) (*MailboxStatus, error) {
+	if len(fields) < 3 {
+		return nil, errors.New("Invalid STATUS response")
+	}
+	name, ok := fields[1].(string)
+	items, isList := fields[2].([]interface{})
+	if !ok || !isList || len(items)%2 != 0 {
+		return nil, errors.New("Invalid STATUS response")
+	}
+	ret := &MailboxStatus{
+		Name: name,
+	}
+	for i := 0; i < len(items); i += 2 {
+		item, _ := items[i].(string)
+		if strings.ToUpper(item) == "MAILBOXID" {
+			id, err := parseObjectID(items[i+1])
+			if err != nil {
+				return nil, err
+			}
+			ret.MailboxID = id
+			continue
+		}
+		n, ok := fieldNumber(items[i+1])
+		if !ok {
+			return nil, errors.New("Invalid STATUS response")
+		}
+		switch strings.ToUpper(item) {
+		case "MESSAGES":
+			ret.Messages = uint32(n)
+		case "RECENT":
+			ret.Recent = uint32(n)
+		case "UIDNEXT":
+			ret.UIDNext = uint32(n)
+		case "UIDVALIDITY":
+			ret.UIDValidity = uint32(n)
+		case "UNSEEN":
+			ret.Unseen = uint32(n)
+		case "DELETED":
+			ret.Deleted = uint32(n)
+		case "SIZE":
+			ret.Size = n
+		case "HIGHESTMODSEQ":
+			ret.HighestModSeq = n
+		}
+	}
+	return ret, nil
+}
@@ -0,0 +1,110 @@
+package imap
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// utf7Encoding is the base64 variant of modified UTF-7, which uses ","
+// instead of "/" and has no padding.
+var utf7Encoding = base64.NewEncoding("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+,").WithPadding(base64.NoPadding)
+
+// EncodeMailbox encodes a mailbox name to the modified UTF-7 of RFC 3501
+// section 5.1.3, like "Entwürfe" to "Entw&APw-rfe".
+func EncodeMailbox(name string) string {
+	ret := make([]byte, 0, len(name))
+	var run []rune
+	flush := func() {
+		if len(run) == 0 {
+			return
+		}
+		units := utf16.Encode(run)
+		b := make([]byte, len(units)*2)
+		for i, u := range units {
+			b[i*2] = byte(u >> 8)
+			b[i*2+1] = byte(u)
+		}
+		ret = append(ret, '&')
+		ret = append(ret, utf7Encoding.EncodeToString(b)...)
+		ret = append(ret, '-')
+		run = run[:0]
+	}
+	for _, r := range name {
+		if 0x20 <= r && r <= 0x7e {
+			flush()
+			ret = append(ret, byte(r))
+			if r == '&' {
+				ret = append(ret, '-')
+			}
+		} else {
+			run = append(run, r)
+		}
+	}
+	flush()
+	return string(ret)
+}
+
+// DecodeMailbox decodes a mailbox name from modified UTF-7.
+func DecodeMailbox(name string) (string, error) {
+	ret := make([]byte, 0, len(name))
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c < 0x20 || c > 0x7e {
+			return "", errors.New("invalid modified UTF-7: non ASCII character")
+		}
+		if c != '&' {
+			ret = append(ret, c)
+			continue
+		}
+		end := strings.IndexByte(name[i:], '-')
+		if end < 0 {
+			return "", errors.New("invalid modified UTF-7: unterminated shift")
+		}
+		encoded := name[i+1 : i+end]
+		i += end
+		if encoded == "" {
+			ret = append(ret, '&')
+			continue
+		}
+		b, err := utf7Encoding.DecodeString(encoded)
+		if err != nil || len(b)%2 != 0 {
+			return "", errors.New("invalid modified UTF-7: bad base64")
+		}
+		units := make([]uint16, len(b)/2)
+		for j := range units {
+			units[j] = uint16(b[j*2])<<8 | uint16(b[j*2+1])
+		}
+		for _, r := range utf16.Decode(units) {
+			if r == utf8.RuneError || (0x20 <= r && r <= 0x7e) {
+				return "", errors.New("invalid modified UTF-7: bad character")
+			}
+			ret = utf8.AppendRune(ret, r)
+		}
+	}
+	return string(ret), nil
+}
+
+// mailboxName makes name a command argument, encoding it to modified UTF-7
+// unless UTF8=ACCEPT is enabled. Then names with control characters aren't
+// valid (RFC 6855), and do refuses to send them.
+func (c *IMAPClient) mailboxName(name string) string {
+	if !c.Enabled("UTF8=ACCEPT") {
+		name = EncodeMailbox(name)
+	}
+	return quoteString(name)
+}
+
+// decodeMailbox is the reverse of mailboxName for names sent by the server.
+// Names that aren't valid modified UTF-7 are kept as they are.
+func (c *IMAPClient) decodeMailbox(name string) string {
+	if c.Enabled("UTF8=ACCEPT") {
+		return name
+	}
+	if decoded, err := DecodeMailbox(name); err == nil {
+		return decoded
+	}
+	return name
+}
@@ -0,0 +1,29 @@
+package imap
+
+import (
+	"errors"
+	"unicode/utf8"
+)
+
+// EnableUTF8 turns on UTF8=ACCEPT (RFC 6855). Afterwards mailbox names are
+// sent and read as UTF-8 instead of modified UTF-7, quoted strings may hold
+// UTF-8, and messages with UTF-8 headers (RFC 6532) can be appended with
+// AppendMessage.UTF8.
+func (c *IMAPClient) EnableUTF8() error {
+	if _, err := c.Enable("UTF8=ACCEPT"); err != nil {
+		return err
+	}
+	if !c.Enabled("UTF8=ACCEPT") {
+		return errors.New("server didn't enable UTF8=ACCEPT")
+	}
+	return nil
+}
+
+// needsLiteral reports whether str can't be sent as a quoted string. Once
+// UTF8=ACCEPT is enabled, only control characters and invalid UTF-8 do.
+func (c *IMAPClient) needsLiteral(str string) bool {
+	if !c.Enabled("UTF8=ACCEPT") {
+		return needsLiteral(str)
+	}
+	return !utf8.ValidString(str) || hasControl(str)
+}